@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"gokit-seed/internal/common"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+// Metrics exposes the OpenTelemetry metrics of the process in the Prometheus
+// text format on the admin listener, or in OpenMetrics with exemplars when the
+// scraper accepts it.
+type Metrics struct {
+	Reader  metric.Reader
+	Handler http.Handler
+}
+
+// NewMetrics returns nil when the admin listener is disabled.
+func NewMetrics() (*Metrics, error) {
+	if common.GetEnv("ADMIN_PORT") == nil {
+		return nil, nil
+	}
+
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprom.New(otelprom.WithRegisterer(registry))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metrics{
+		Reader:  exporter,
+		Handler: promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	}, nil
+}
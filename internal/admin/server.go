@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/profiling"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"slices"
+	"strings"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Route is an extra page served by the admin listener. Provide it in the
+// "admin_routes" fx group to have it registered.
+type Route struct {
+	Path    string
+	Handler http.Handler
+}
+
+type Server struct {
+	*http.Server
+}
+
+// NewServer starts the admin listener on ADMIN_HOST:ADMIN_PORT (localhost by
+// default). It returns nil when ADMIN_PORT is not set.
+func NewServer(
+	lc fx.Lifecycle,
+	logger *zap.Logger,
+	level zap.AtomicLevel,
+	metrics *Metrics,
+	routes []*common.RouteGroup,
+	adminRoutes []Route,
+	profiler *profiling.Profiler,
+) (*Server, error) {
+	port := common.GetEnv("ADMIN_PORT")
+
+	if port == nil {
+		return nil, nil
+	}
+
+	auth, err := withAuth()
+
+	if err != nil {
+		return nil, err
+	}
+
+	host := "localhost"
+	if h := common.GetEnv("ADMIN_HOST"); h != nil {
+		host = *h
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.Handle("/debug/pprof/profile", profiler.Yield(http.HandlerFunc(pprof.Profile)))
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/loglevel", level)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(routeTable(routes))
+	})
+
+	if metrics != nil {
+		mux.Handle("/metrics", metrics.Handler)
+	}
+
+	for _, route := range adminRoutes {
+		mux.Handle(route.Path, route.Handler)
+	}
+
+	server := &Server{&http.Server{
+		Addr:    net.JoinHostPort(host, *port),
+		Handler: auth(mux),
+	}}
+
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			ln, err := net.Listen("tcp", server.Addr)
+
+			if err != nil {
+				return err
+			}
+
+			logger.Info(
+				"admin server is listening",
+				zap.String("addr", server.Addr),
+			)
+			go server.Serve(ln)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logger.Info("admin server is shutting down")
+			return server.Shutdown(ctx)
+		},
+	})
+
+	return server, nil
+}
+
+// routeTable lists the routes of every group once, as groups sharing a
+// router list the same routes.
+func routeTable(routes []*common.RouteGroup) []common.RouteInfo {
+	var table []common.RouteInfo
+
+	for _, route := range routes {
+		for _, info := range route.Routes() {
+			if !slices.Contains(table, info) {
+				table = append(table, info)
+			}
+		}
+	}
+
+	return table
+}
+
+// withAuth protects the admin listener with basic auth (ADMIN_USERNAME and
+// ADMIN_PASSWORD) or a bearer token (ADMIN_TOKEN) when either is configured.
+// A username without a password, or the other way around, is an error
+// rather than an open listener.
+func withAuth() (common.HandleChain, error) {
+	username := os.Getenv("ADMIN_USERNAME")
+	password := os.Getenv("ADMIN_PASSWORD")
+	token := os.Getenv("ADMIN_TOKEN")
+
+	if (username == "") != (password == "") {
+		return nil, errors.New("ADMIN_USERNAME and ADMIN_PASSWORD must be set together")
+	}
+
+	return func(next http.Handler) http.Handler {
+		if username == "" && token == "" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(bearer, token) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			if username != "" {
+				if u, p, ok := r.BasicAuth(); ok && secureEqual(u, username) && secureEqual(p, password) {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			}
+
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		})
+	}, nil
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"gokit-seed/internal/common"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRouteTableListsSharedRoutersOnce(t *testing.T) {
+	api := common.NewRouteGroup("/api")
+	v1 := api.NewGroup("/v1")
+	api.HandlerFunc("GET", "/status", http.NotFound)
+	v1.HandlerFunc("POST", "/items", http.NotFound)
+
+	other := common.NewRouteGroup("/other")
+	other.HandlerFunc("GET", "/status", http.NotFound)
+
+	want := []common.RouteInfo{
+		{Method: "GET", Path: "/api/status"},
+		{Method: "POST", Path: "/api/v1/items"},
+		{Method: "GET", Path: "/other/status"},
+	}
+
+	if got := routeTable([]*common.RouteGroup{api, v1, other}); !slices.Equal(got, want) {
+		t.Fatalf("routes %v, want %v", got, want)
+	}
+}
+
+func TestWithAuthRejectsPartialCredentials(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"ADMIN_USERNAME": "admin"},
+		{"ADMIN_PASSWORD": "secret"},
+		{"ADMIN_PASSWORD": "secret", "ADMIN_TOKEN": "token"},
+	} {
+		t.Setenv("ADMIN_USERNAME", env["ADMIN_USERNAME"])
+		t.Setenv("ADMIN_PASSWORD", env["ADMIN_PASSWORD"])
+		t.Setenv("ADMIN_TOKEN", env["ADMIN_TOKEN"])
+
+		if _, err := withAuth(); err == nil {
+			t.Errorf("%v accepted", env)
+		}
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	t.Setenv("ADMIN_USERNAME", "admin")
+	t.Setenv("ADMIN_PASSWORD", "secret")
+	t.Setenv("ADMIN_TOKEN", "token")
+
+	auth, err := withAuth()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := auth(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for _, tc := range []struct {
+		username, password, token string
+		want                      int
+	}{
+		{"admin", "secret", "", http.StatusOK},
+		{"", "", "token", http.StatusOK},
+		{"admin", "", "", http.StatusUnauthorized},
+		{"admin", "wrong", "", http.StatusUnauthorized},
+		{"", "", "wrong", http.StatusUnauthorized},
+		{"", "", "", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+
+		if tc.username != "" {
+			r.SetBasicAuth(tc.username, tc.password)
+		}
+
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.want {
+			t.Errorf("%+v answered %d, want %d", tc, w.Code, tc.want)
+		}
+	}
+}
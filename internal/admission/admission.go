@@ -0,0 +1,347 @@
+package admission
+
+import (
+	"context"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/common"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const HeaderPriority = "X-Priority"
+
+// Priority classes, the lower the later requests are shed.
+const (
+	Critical = iota
+	Normal
+	Sheddable
+	priorities
+)
+
+var priorityNames = [priorities]string{"critical", "normal", "sheddable"}
+
+// PriorityOf reads the X-Priority header, "critical", "normal" or
+// "sheddable". Anyone may lower the priority of their requests, but only
+// authenticated services, such as callers of signed requests, may raise it:
+// other callers are normal.
+func PriorityOf(r *http.Request) int {
+	switch r.Header.Get(HeaderPriority) {
+	case "critical":
+		if principal := auth.PrincipalFromContext(r.Context()); principal != nil && principal.HasRole("service") {
+			return Critical
+		}
+	case "sheddable", "low":
+		return Sheddable
+	}
+
+	return Normal
+}
+
+var (
+	registerOnce  sync.Once
+	requests      metric.Int64Counter
+	queueDuration metric.Float64Histogram
+)
+
+func registerMetrics() {
+	meter := otel.Meter("gokit-seed/admission")
+
+	requests, _ = meter.Int64Counter(
+		"admission.requests",
+		metric.WithDescription("Requests by outcome: admitted, queued, rejected, evicted from the queue or timed out in it"),
+	)
+
+	queueDuration, _ = meter.Float64Histogram(
+		"admission.queue.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Time requests waited for a slot"),
+		metric.WithExplicitBucketBoundaries(0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5),
+	)
+}
+
+type Config struct {
+	Algorithm string
+	Bounds    Bounds
+	// MaxQueue requests wait up to QueueTimeout once the limit is reached.
+	MaxQueue     int
+	QueueTimeout time.Duration
+	RetryAfter   time.Duration
+}
+
+// Controller limits the concurrency of each route group. A nil *Controller
+// means admission control is disabled.
+type Controller struct {
+	cfg    Config
+	logger *zap.Logger
+	mu     sync.Mutex
+	groups []*Limiter
+}
+
+// NewController configures admission control from the environment:
+// ADMISSION_ALGORITHM enables it with "aimd", "vegas" or "gradient",
+// ADMISSION_INITIAL_LIMIT, ADMISSION_MIN_LIMIT and ADMISSION_MAX_LIMIT bound
+// the limit, ADMISSION_QUEUE and ADMISSION_QUEUE_TIMEOUT size the queue and
+// ADMISSION_RETRY_AFTER is sent with rejections.
+func NewController(logger *zap.Logger) (*Controller, error) {
+	name := os.Getenv("ADMISSION_ALGORITHM")
+
+	if name == "" {
+		return nil, nil
+	}
+
+	cfg := Config{
+		Algorithm: name,
+		Bounds: Bounds{
+			Initial: common.DefaultGetEnvInt("ADMISSION_INITIAL_LIMIT", 20),
+			Min:     common.DefaultGetEnvInt("ADMISSION_MIN_LIMIT", 1),
+			Max:     common.DefaultGetEnvInt("ADMISSION_MAX_LIMIT", 1000),
+		},
+		MaxQueue:     common.DefaultGetEnvInt("ADMISSION_QUEUE", 50),
+		QueueTimeout: common.DefaultGetEnvDuration("ADMISSION_QUEUE_TIMEOUT", 100*time.Millisecond),
+		RetryAfter:   common.DefaultGetEnvDuration("ADMISSION_RETRY_AFTER", time.Second),
+	}
+
+	// Fail at startup rather than when the first group is added.
+	if _, err := NewAlgorithm(cfg.Algorithm, cfg.Bounds); err != nil {
+		return nil, err
+	}
+
+	registerOnce.Do(registerMetrics)
+
+	c := &Controller{cfg: cfg, logger: logger}
+
+	_, err := otel.Meter("gokit-seed/admission").Int64ObservableGauge(
+		"admission.limit",
+		metric.WithDescription("Concurrency limit, requests in flight and queued by route group"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			c.mu.Lock()
+			groups := c.groups
+			c.mu.Unlock()
+
+			for _, l := range groups {
+				limit, inflight, queued := l.State()
+				o.Observe(int64(limit), metric.WithAttributeSet(l.attrs), metric.WithAttributes(attribute.String("state", "limit")))
+				o.Observe(int64(inflight), metric.WithAttributeSet(l.attrs), metric.WithAttributes(attribute.String("state", "inflight")))
+				o.Observe(int64(queued), metric.WithAttributeSet(l.attrs), metric.WithAttributes(attribute.String("state", "queued")))
+			}
+
+			return nil
+		}),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Group returns a common.HandleChain admitting requests to the route group
+// named group under a limit shared by every handler it wraps. It goes
+// within the access log and the route metrics, so that shed requests are
+// accounted for, and after authentication, which PriorityOf relies on.
+func (c *Controller) Group(group string) common.HandleChain {
+	if c == nil {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	algorithm, _ := NewAlgorithm(c.cfg.Algorithm, c.cfg.Bounds)
+	l := NewLimiter(group, algorithm, c.cfg.MaxQueue, c.cfg.QueueTimeout)
+
+	c.mu.Lock()
+	c.groups = append(c.groups, l)
+	c.mu.Unlock()
+
+	retryAfter := strconv.Itoa(int(math.Ceil(c.cfg.RetryAfter.Seconds())))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			priority := PriorityOf(r)
+
+			if !l.Acquire(r.Context(), priority) {
+				common.LoggerFromContext(r.Context()).Debug(
+					"request shed",
+					zap.String("group", group),
+					zap.String("priority", priorityNames[priority]),
+				)
+
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+				return
+			}
+
+			start := time.Now()
+			writer := common.NewStatusWriter(w)
+
+			next.ServeHTTP(writer, r)
+
+			dropped := writer.Status == http.StatusServiceUnavailable ||
+				writer.Status == http.StatusGatewayTimeout ||
+				r.Context().Err() != nil
+			l.Release(time.Since(start), dropped)
+		})
+	}
+}
+
+type waiter struct {
+	ready chan bool
+}
+
+// Limiter admits requests while fewer than the limit of its algorithm are in
+// flight, then queues them by priority.
+type Limiter struct {
+	algorithm    Algorithm
+	maxQueue     int
+	queueTimeout time.Duration
+	attrs        attribute.Set
+
+	mu       sync.Mutex
+	inflight int
+	queued   int
+	queues   [priorities][]*waiter
+}
+
+func NewLimiter(group string, algorithm Algorithm, maxQueue int, queueTimeout time.Duration) *Limiter {
+	registerOnce.Do(registerMetrics)
+
+	return &Limiter{
+		algorithm:    algorithm,
+		maxQueue:     maxQueue,
+		queueTimeout: queueTimeout,
+		attrs:        attribute.NewSet(attribute.String("group", group)),
+	}
+}
+
+func (l *Limiter) State() (limit, inflight, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.algorithm.Limit(), l.inflight, l.queued
+}
+
+// Acquire reports whether the request may proceed, in which case Release
+// must be called once it completes. When the queue is full, a request evicts
+// the newest waiter of a lower priority if any.
+func (l *Limiter) Acquire(ctx context.Context, priority int) bool {
+	l.mu.Lock()
+
+	if l.inflight < l.algorithm.Limit() {
+		l.inflight++
+		l.mu.Unlock()
+		l.record(ctx, priority, "admitted")
+		return true
+	}
+
+	if l.queued >= l.maxQueue && !l.evict(priority) {
+		l.mu.Unlock()
+		l.record(ctx, priority, "rejected")
+		return false
+	}
+
+	w := &waiter{ready: make(chan bool, 1)}
+	l.queues[priority] = append(l.queues[priority], w)
+	l.queued++
+	l.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	var admitted, signaled bool
+
+	select {
+	case admitted = <-w.ready:
+		signaled = true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	if !signaled {
+		l.mu.Lock()
+		removed := l.remove(priority, w)
+		l.mu.Unlock()
+
+		if removed {
+			l.record(ctx, priority, "timeout")
+			return false
+		}
+
+		// Admitted or evicted while giving up.
+		admitted = <-w.ready
+	}
+
+	queueDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributeSet(l.attrs))
+
+	if !admitted {
+		l.record(ctx, priority, "evicted")
+		return false
+	}
+
+	l.record(ctx, priority, "queued")
+	return true
+}
+
+// evict rejects the newest waiter of the lowest priority below priority.
+func (l *Limiter) evict(priority int) bool {
+	for p := priorities - 1; p > priority; p-- {
+		if n := len(l.queues[p]); n > 0 {
+			w := l.queues[p][n-1]
+			l.queues[p] = l.queues[p][:n-1]
+			l.queued--
+			w.ready <- false
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Limiter) remove(priority int, w *waiter) bool {
+	for i, queued := range l.queues[priority] {
+		if queued == w {
+			l.queues[priority] = append(l.queues[priority][:i], l.queues[priority][i+1:]...)
+			l.queued--
+			return true
+		}
+	}
+
+	return false
+}
+
+// Release updates the limit with the outcome of an admitted request and
+// hands the freed slots to the waiters of the highest priority.
+func (l *Limiter) Release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.algorithm.Update(rtt, l.inflight, dropped)
+	l.inflight--
+
+	for p := range l.queues {
+		for len(l.queues[p]) > 0 && l.inflight < l.algorithm.Limit() {
+			w := l.queues[p][0]
+			l.queues[p] = l.queues[p][1:]
+			l.queued--
+			l.inflight++
+			w.ready <- true
+		}
+	}
+}
+
+func (l *Limiter) record(ctx context.Context, priority int, outcome string) {
+	requests.Add(ctx, 1, metric.WithAttributeSet(l.attrs), metric.WithAttributes(
+		attribute.String("priority", priorityNames[priority]),
+		attribute.String("outcome", outcome),
+	))
+}
@@ -0,0 +1,121 @@
+package admission
+
+import (
+	"context"
+	"gokit-seed/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPriorityOf(t *testing.T) {
+	service := &auth.Principal{Subject: "service:billing", Roles: []string{"service"}}
+	user := &auth.Principal{Subject: "user"}
+
+	for _, tc := range []struct {
+		priority  string
+		userAgent string
+		principal *auth.Principal
+		want      int
+	}{
+		{"", "", nil, Normal},
+		{"critical", "", service, Critical},
+		{"critical", "", user, Normal},
+		{"critical", "", nil, Normal},
+		{"", "kube-probe/1.30", nil, Normal},
+		{"sheddable", "", nil, Sheddable},
+		{"low", "", user, Sheddable},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(HeaderPriority, tc.priority)
+		r.Header.Set("User-Agent", tc.userAgent)
+
+		if tc.principal != nil {
+			r = r.WithContext(auth.ContextWithPrincipal(r.Context(), tc.principal))
+		}
+
+		if got := PriorityOf(r); got != tc.want {
+			t.Errorf("%q from %v = %s, want %s", tc.priority, tc.principal, priorityNames[got], priorityNames[tc.want])
+		}
+	}
+}
+
+func TestLimiterQueuesByPriority(t *testing.T) {
+	l := NewLimiter("test", NewAIMD(Bounds{Initial: 1, Min: 1, Max: 1}, 0.9), 1, time.Second)
+	ctx := context.Background()
+
+	if !l.Acquire(ctx, Normal) {
+		t.Fatal("request under the limit not admitted")
+	}
+
+	sheddable := make(chan bool)
+	go func() { sheddable <- l.Acquire(ctx, Sheddable) }()
+
+	for _, _, queued := l.State(); queued == 0; _, _, queued = l.State() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, a critical request evicts the sheddable one.
+	critical := make(chan bool)
+	go func() { critical <- l.Acquire(ctx, Critical) }()
+
+	if <-sheddable {
+		t.Fatal("sheddable request not evicted")
+	}
+
+	l.Release(time.Millisecond, false)
+
+	if !<-critical {
+		t.Fatal("critical request not admitted")
+	}
+
+	// A full queue without lower priorities rejects.
+	go l.Acquire(ctx, Normal)
+
+	for _, _, queued := l.State(); queued == 0; _, _, queued = l.State() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if l.Acquire(ctx, Normal) {
+		t.Fatal("request over the queue admitted")
+	}
+
+	l.Release(time.Millisecond, false)
+}
+
+func TestGroupShedsWithRetryAfter(t *testing.T) {
+	c := &Controller{cfg: Config{
+		Algorithm:    "aimd",
+		Bounds:       Bounds{Initial: 1, Min: 1, Max: 1},
+		QueueTimeout: time.Millisecond,
+		RetryAfter:   1500 * time.Millisecond,
+	}}
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	handler := c.Group("/test")(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	close(release)
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("shed with %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Disabled controllers pass requests through.
+	var disabled *Controller
+	w = httptest.NewRecorder()
+	disabled.Group("/test")(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("disabled controller answered %d", w.Code)
+	}
+}
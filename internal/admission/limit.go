@@ -0,0 +1,175 @@
+package admission
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Algorithm adapts a concurrency limit to the latency of the requests it
+// admitted. Its methods are called under the lock of a single limiter.
+type Algorithm interface {
+	Limit() int
+	// Update reports a request that completed after rtt while inflight
+	// requests, itself included, were in progress. Dropped requests timed
+	// out or were refused downstream.
+	Update(rtt time.Duration, inflight int, dropped bool)
+}
+
+type Bounds struct {
+	Initial int
+	Min     int
+	Max     int
+}
+
+func (b Bounds) clamp(limit float64) float64 {
+	return math.Min(math.Max(limit, float64(b.Min)), float64(b.Max))
+}
+
+// NewAlgorithm returns "aimd", "vegas" or "gradient".
+func NewAlgorithm(name string, bounds Bounds) (Algorithm, error) {
+	switch name {
+	case "aimd":
+		return NewAIMD(bounds, 0.9), nil
+	case "vegas":
+		return NewVegas(bounds), nil
+	case "gradient":
+		return NewGradient(bounds, 2, 0.2), nil
+	default:
+		return nil, fmt.Errorf("invalid admission algorithm %q", name)
+	}
+}
+
+// AIMD grows the limit by one while requests succeed with the limit in use,
+// and multiplies it by backoff when a request is dropped.
+type AIMD struct {
+	bounds  Bounds
+	backoff float64
+	limit   float64
+}
+
+func NewAIMD(bounds Bounds, backoff float64) *AIMD {
+	return &AIMD{bounds: bounds, backoff: backoff, limit: float64(bounds.Initial)}
+}
+
+func (a *AIMD) Limit() int {
+	return int(a.limit)
+}
+
+func (a *AIMD) Update(_ time.Duration, inflight int, dropped bool) {
+	switch {
+	case dropped:
+		a.limit = a.bounds.clamp(a.limit * a.backoff)
+	// Only grow when the limit is what holds requests back.
+	case float64(inflight)*2 >= a.limit:
+		a.limit = a.bounds.clamp(a.limit + 1)
+	}
+}
+
+// vegasProbe is how many times the limit of samples Vegas takes before
+// probing the base rtt again.
+const vegasProbe = 30
+
+// Vegas estimates the requests queued downstream from how much rtt exceeds
+// the lowest rtt seen, and keeps that queue between alpha and beta requests
+// scaled to log10 of the limit. The lowest rtt is forgotten every
+// vegasProbe times the limit of samples, so that a downstream which became
+// slower for good, or a single fast route of the group, does not hold the
+// limit down forever.
+type Vegas struct {
+	bounds  Bounds
+	limit   float64
+	baseRtt time.Duration
+	samples int
+}
+
+func NewVegas(bounds Bounds) *Vegas {
+	return &Vegas{bounds: bounds, limit: float64(bounds.Initial)}
+}
+
+func (v *Vegas) Limit() int {
+	return int(v.limit)
+}
+
+func (v *Vegas) Update(rtt time.Duration, inflight int, dropped bool) {
+	if rtt <= 0 {
+		return
+	}
+
+	v.samples++
+
+	// Probing takes the current rtt as the base, leaving the limit as is.
+	if float64(v.samples) >= vegasProbe*v.limit {
+		v.samples = 0
+		v.baseRtt = rtt
+		return
+	}
+
+	if v.baseRtt == 0 || rtt < v.baseRtt {
+		v.baseRtt = rtt
+	}
+
+	step := math.Max(1, math.Log10(v.limit))
+
+	if dropped {
+		v.limit = v.bounds.clamp(v.limit - step)
+		return
+	}
+
+	queue := v.limit * (1 - float64(v.baseRtt)/float64(rtt))
+	alpha, beta := 3*step, 6*step
+
+	switch {
+	case queue > beta:
+		v.limit = v.bounds.clamp(v.limit - step)
+	case queue < alpha && float64(inflight)*2 >= v.limit:
+		v.limit = v.bounds.clamp(v.limit + step)
+	}
+}
+
+// Gradient scales the limit by the ratio of a long term average rtt, with
+// some tolerance, to the last rtt, and adds a queue of sqrt(limit) requests.
+// Changes are smoothed so a single slow request barely moves the limit.
+type Gradient struct {
+	bounds    Bounds
+	tolerance float64
+	smoothing float64
+	limit     float64
+	longRtt   float64
+}
+
+func NewGradient(bounds Bounds, tolerance, smoothing float64) *Gradient {
+	return &Gradient{bounds: bounds, tolerance: tolerance, smoothing: smoothing, limit: float64(bounds.Initial)}
+}
+
+func (g *Gradient) Limit() int {
+	return int(g.limit)
+}
+
+func (g *Gradient) Update(rtt time.Duration, inflight int, dropped bool) {
+	if rtt <= 0 {
+		return
+	}
+
+	sample := float64(rtt)
+
+	if g.longRtt == 0 {
+		g.longRtt = sample
+	} else {
+		g.longRtt += (sample - g.longRtt) / 100
+	}
+
+	// Do not grow a limit that is not in use.
+	if !dropped && float64(inflight)*2 < g.limit {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRtt/sample))
+
+	if dropped {
+		gradient = 0.5
+	}
+
+	target := g.limit*gradient + math.Sqrt(g.limit)
+	g.limit = g.bounds.clamp(g.limit*(1-g.smoothing) + target*g.smoothing)
+}
@@ -0,0 +1,144 @@
+package admission
+
+import (
+	"testing"
+	"time"
+)
+
+var testBounds = Bounds{Initial: 20, Min: 1, Max: 100}
+
+func TestNewAlgorithm(t *testing.T) {
+	for _, name := range []string{"aimd", "vegas", "gradient"} {
+		algorithm, err := NewAlgorithm(name, testBounds)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if algorithm.Limit() != testBounds.Initial {
+			t.Errorf("%s starts at %d", name, algorithm.Limit())
+		}
+	}
+
+	if _, err := NewAlgorithm("bbr", testBounds); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+func TestAIMD(t *testing.T) {
+	a := NewAIMD(testBounds, 0.5)
+
+	// Unused limits do not grow.
+	a.Update(time.Millisecond, 1, false)
+
+	if a.Limit() != 20 {
+		t.Fatalf("idle limit grew to %d", a.Limit())
+	}
+
+	a.Update(time.Millisecond, 20, false)
+
+	if a.Limit() != 21 {
+		t.Fatalf("limit %d after a success", a.Limit())
+	}
+
+	a.Update(time.Millisecond, 20, true)
+
+	if a.Limit() != 10 {
+		t.Fatalf("limit %d after a drop", a.Limit())
+	}
+
+	for i := 0; i < 10; i++ {
+		a.Update(time.Millisecond, 1, true)
+	}
+
+	if a.Limit() != testBounds.Min {
+		t.Fatalf("limit %d below the minimum", a.Limit())
+	}
+}
+
+func TestVegas(t *testing.T) {
+	v := NewVegas(testBounds)
+
+	// No queue at the base rtt, the limit grows.
+	for i := 0; i < 5; i++ {
+		v.Update(10*time.Millisecond, v.Limit(), false)
+	}
+
+	grown := v.Limit()
+
+	if grown <= testBounds.Initial {
+		t.Fatalf("limit %d at the base rtt", grown)
+	}
+
+	// Twice the base rtt means half the limit is queued.
+	v.Update(20*time.Millisecond, grown, false)
+
+	if v.Limit() >= grown {
+		t.Fatalf("limit %d with a queue, was %d", v.Limit(), grown)
+	}
+
+	limit := v.Limit()
+	v.Update(10*time.Millisecond, limit, true)
+
+	if v.Limit() >= limit {
+		t.Fatalf("limit %d after a drop, was %d", v.Limit(), limit)
+	}
+}
+
+func TestVegasProbesTheBaseRtt(t *testing.T) {
+	v := NewVegas(Bounds{Initial: 10, Min: 10, Max: 100})
+	v.Update(time.Millisecond, 10, false)
+
+	// The downstream got slower for good, once probed that is the new base.
+	for i := 0; i < vegasProbe*10; i++ {
+		v.Update(50*time.Millisecond, v.Limit(), false)
+	}
+
+	if v.baseRtt != 50*time.Millisecond {
+		t.Fatalf("base rtt %s not probed", v.baseRtt)
+	}
+
+	v.Update(50*time.Millisecond, v.Limit(), false)
+
+	if v.Limit() <= 10 {
+		t.Fatalf("limit %d held down after probing", v.Limit())
+	}
+}
+
+func TestGradient(t *testing.T) {
+	g := NewGradient(testBounds, 2, 0.2)
+
+	for i := 0; i < 20; i++ {
+		g.Update(10*time.Millisecond, g.Limit(), false)
+	}
+
+	grown := g.Limit()
+
+	if grown <= testBounds.Initial {
+		t.Fatalf("limit %d at a steady rtt", grown)
+	}
+
+	// Within the tolerance of the long term rtt, the queue keeps growing it.
+	g.Update(15*time.Millisecond, grown, false)
+
+	if g.Limit() < grown {
+		t.Fatalf("limit %d within the tolerance, was %d", g.Limit(), grown)
+	}
+
+	limit := g.Limit()
+
+	for i := 0; i < 5; i++ {
+		g.Update(100*time.Millisecond, g.Limit(), false)
+	}
+
+	if g.Limit() >= limit {
+		t.Fatalf("limit %d with rtt ten times longer, was %d", g.Limit(), limit)
+	}
+
+	limit = g.Limit()
+	g.Update(10*time.Millisecond, 1, true)
+
+	if g.Limit() >= limit {
+		t.Fatalf("limit %d after a drop, was %d", g.Limit(), limit)
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error is an authentication or authorization failure. It implements the
+// go-kit StatusCoder, Headerer and json.Marshaler interfaces so that
+// kithttp.DefaultErrorEncoder renders it as a JSON error response.
+type Error struct {
+	Code    int
+	Reason  string
+	Message string
+	cause   error
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func (e *Error) StatusCode() int {
+	return e.Code
+}
+
+func (e *Error) Headers() http.Header {
+	if e.Code != http.StatusUnauthorized {
+		return nil
+	}
+
+	return http.Header{"WWW-Authenticate": []string{`Bearer error="` + e.Reason + `"`}}
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"error":   e.Reason,
+		"message": e.Message,
+	})
+}
+
+var ErrMissingToken = &Error{
+	Code:    http.StatusUnauthorized,
+	Reason:  "invalid_request",
+	Message: "missing bearer token",
+}
+
+func ErrInvalidToken(cause error) *Error {
+	return &Error{
+		Code:    http.StatusUnauthorized,
+		Reason:  "invalid_token",
+		Message: "invalid bearer token",
+		cause:   cause,
+	}
+}
+
+func ErrInsufficientScope(scope string) *Error {
+	return &Error{
+		Code:    http.StatusForbidden,
+		Reason:  "insufficient_scope",
+		Message: "missing required scope " + scope,
+	}
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// KeySet is a JSON Web Key Set loaded from a file or a URL. Keys are cached
+// and reloaded every refreshInterval, or earlier when a token references an
+// unknown key id, so that rotated keys are picked up without a restart.
+// Concurrent reloads are coalesced and, failed or not, reloads are at least
+// minRefreshInterval apart so that an unavailable source is not hammered.
+type KeySet struct {
+	load               func(ctx context.Context) ([]byte, error)
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	loadTimeout        time.Duration
+	loads              singleflight.Group
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	secrets     map[string][]byte
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func NewFileKeySet(path string, refreshInterval time.Duration) *KeySet {
+	return newKeySet(func(_ context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	}, refreshInterval)
+}
+
+func NewUrlKeySet(url string, client *http.Client, refreshInterval time.Duration) *KeySet {
+	return newKeySet(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching jwks: unexpected status %s", res.Status)
+		}
+
+		return io.ReadAll(res.Body)
+	}, refreshInterval)
+}
+
+func newKeySet(load func(ctx context.Context) ([]byte, error), refreshInterval time.Duration) *KeySet {
+	return &KeySet{
+		load:               load,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: 30 * time.Second,
+		loadTimeout:        10 * time.Second,
+	}
+}
+
+// Key returns the key with the given id, either a public key or an HMAC secret.
+func (s *KeySet) Key(ctx context.Context, kid string) (any, error) {
+	s.mu.RLock()
+	key, found := s.lookup(kid)
+	stale := time.Since(s.fetchedAt) > s.refreshInterval
+	canRefresh := time.Since(s.attemptedAt) > s.minRefreshInterval
+	s.mu.RUnlock()
+
+	if found && (!stale || !canRefresh) {
+		return key, nil
+	}
+
+	if !canRefresh {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		if found {
+			// Keep serving the cached key when the source is temporarily unavailable.
+			return key, nil
+		}
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if key, found := s.lookup(kid); found {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (s *KeySet) lookup(kid string) (any, bool) {
+	if key, ok := s.keys[kid]; ok {
+		return key, true
+	}
+
+	if secret, ok := s.secrets[kid]; ok {
+		return secret, true
+	}
+
+	return nil, false
+}
+
+// refresh reloads the keys once for all concurrent callers. The load is
+// detached from the caller, who only gives up waiting for it.
+func (s *KeySet) refresh(ctx context.Context) error {
+	loaded := s.loads.DoChan("", func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.loadTimeout)
+		defer cancel()
+
+		err := s.reload(loadCtx)
+
+		s.mu.Lock()
+		s.attemptedAt = time.Now()
+		s.mu.Unlock()
+
+		return nil, err
+	})
+
+	select {
+	case result := <-loaded:
+		return result.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *KeySet) reload(ctx context.Context) error {
+	data, err := s.load(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("parsing jwks: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	secrets := map[string][]byte{}
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		switch jwk.Kty {
+		case "RSA":
+			key, err := parseRsaKey(jwk)
+			if err != nil {
+				return err
+			}
+			keys[jwk.Kid] = key
+		case "EC":
+			key, err := parseEcKey(jwk)
+			if err != nil {
+				return err
+			}
+			keys[jwk.Kid] = key
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+			if err != nil {
+				return fmt.Errorf("parsing jwk %q: %w", jwk.Kid, err)
+			}
+			secrets[jwk.Kid] = secret
+		}
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.secrets = secrets
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+
+	return nil
+}
+
+func parseRsaKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwk %q: %w", jwk.Kid, err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwk %q: %w", jwk.Kid, err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func parseEcKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("parsing jwk %q: unsupported curve %q", jwk.Kid, jwk.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwk %q: %w", jwk.Kid, err)
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwk %q: %w", jwk.Kid, err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
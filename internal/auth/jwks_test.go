@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves the JSON Web Key Set it is given, or fails.
+type jwksServer struct {
+	*httptest.Server
+	fetches atomic.Int32
+	mu      sync.Mutex
+	set     []byte
+	release chan struct{}
+}
+
+func newJwksServer(t *testing.T, set []byte) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{set: set}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		set, release := s.set, s.release
+		s.mu.Unlock()
+
+		if release != nil {
+			<-release
+		}
+
+		if set == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write(set)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *jwksServer) serve(set []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set = set
+}
+
+func TestKeySetPicksUpRotatedKeys(t *testing.T) {
+	old, rotated := newEcKey(t, "old"), newRsaKey(t, "new")
+	server := newJwksServer(t, jwks(t, old))
+	keys := NewUrlKeySet(server.URL, server.Client(), time.Hour)
+	keys.minRefreshInterval = 0
+	ctx := context.Background()
+
+	if _, err := keys.Key(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.serve(jwks(t, rotated))
+
+	// Unknown key ids trigger a reload.
+	if _, err := keys.Key(ctx, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := keys.Key(ctx, "old"); err == nil {
+		t.Fatal("rotated out key still accepted")
+	}
+
+	// Known keys are served from the cache until stale.
+	fetches := server.fetches.Load()
+	keys.Key(ctx, "new")
+
+	if server.fetches.Load() != fetches {
+		t.Fatal("fresh key reloaded")
+	}
+}
+
+func TestKeySetBacksOffWhileTheSourceIsDown(t *testing.T) {
+	key := newRsaKey(t, "rsa")
+	server := newJwksServer(t, jwks(t, key))
+	keys := NewUrlKeySet(server.URL, server.Client(), time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := keys.Key(ctx, "rsa"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.serve(nil)
+	// Stale, and as if minRefreshInterval had passed since the first load.
+	time.Sleep(2 * time.Millisecond)
+	keys.attemptedAt = time.Time{}
+
+	// The stale key keeps being served, with a single failed reload.
+	for i := 0; i < 10; i++ {
+		if _, err := keys.Key(ctx, "rsa"); err != nil {
+			t.Fatalf("cached key not served: %v", err)
+		}
+	}
+
+	// Unknown key ids do not reload either until minRefreshInterval passed.
+	if _, err := keys.Key(ctx, "other"); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+
+	if fetches := server.fetches.Load(); fetches != 2 {
+		t.Fatalf("%d fetches, want 2", fetches)
+	}
+}
+
+func TestKeySetCoalescesReloads(t *testing.T) {
+	key := newEcKey(t, "ec")
+	server := newJwksServer(t, jwks(t, key))
+	release := make(chan struct{})
+	server.release = release
+	keys := NewUrlKeySet(server.URL, server.Client(), time.Hour)
+
+	// A caller giving up does not cancel the shared load.
+	canceled, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := keys.Key(canceled, "ec"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("canceled caller got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			_, err := keys.Key(context.Background(), "ec")
+			errs <- err
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fetches := server.fetches.Load(); fetches != 1 {
+		t.Fatalf("%d fetches, want 1", fetches)
+	}
+}
+
+func TestVerifyWithJwksUrl(t *testing.T) {
+	key := newRsaKey(t, "rsa")
+	server := newJwksServer(t, jwks(t, key))
+	v := newTestVerifier(t, map[string]string{"AUTH_JWKS_URL": server.URL})
+
+	if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"gokit-seed/internal/common"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// HTTPToContext moves the bearer token of a request into the context, to be
+// validated later by the endpoint middleware returned by Verifier.NewParser.
+func HTTPToContext() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if token, ok := bearerToken(r); ok {
+			return ContextWithToken(ctx, token)
+		}
+
+		return ctx
+	}
+}
+
+type withAuthentication struct {
+	v    *Verifier
+	next http.Handler
+}
+
+// Authenticate is a common.HandleChain rejecting unauthenticated requests
+// without a valid bearer token. The principal is put into the request context
+// and its subject is added to the request logger and the current span.
+func (v *Verifier) Authenticate(next http.Handler) http.Handler {
+	if v == nil {
+		return next
+	}
+
+	return &withAuthentication{v, next}
+}
+
+func (h *withAuthentication) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Already authenticated, e.g. by Keyring.VerifySignature.
+	if PrincipalFromContext(ctx) != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	token, ok := bearerToken(r)
+
+	if !ok {
+		kithttp.DefaultErrorEncoder(ctx, ErrMissingToken, w)
+		return
+	}
+
+	principal, err := h.v.Verify(ctx, token)
+
+	if err != nil {
+		common.LoggerFromContext(ctx).Debug("rejected bearer token", zap.Error(err))
+		kithttp.DefaultErrorEncoder(ctx, err, w)
+		return
+	}
+
+	ctx = contextWithSubject(ctx, principal)
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// NewParser is the endpoint counterpart of Authenticate, validating the token
+// stored in the context by HTTPToContext. Requests already authenticated,
+// e.g. by Authenticate or Keyring.VerifySignature, are let through.
+func (v *Verifier) NewParser() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if v == nil {
+			return next
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if PrincipalFromContext(ctx) != nil {
+				return next(ctx, request)
+			}
+
+			token, ok := TokenFromContext(ctx)
+
+			if !ok {
+				return nil, ErrMissingToken
+			}
+
+			principal, err := v.Verify(ctx, token)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return next(contextWithSubject(ctx, principal), request)
+		}
+	}
+}
+
+// RequireScopes rejects callers whose token lacks any of the given scopes.
+func (v *Verifier) RequireScopes(scopes ...string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if v == nil {
+			return next
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal := PrincipalFromContext(ctx)
+
+			if principal == nil {
+				return nil, ErrMissingToken
+			}
+
+			for _, scope := range scopes {
+				if !principal.HasScope(scope) {
+					return nil, ErrInsufficientScope(scope)
+				}
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+func contextWithSubject(ctx context.Context, principal *Principal) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("enduser.id", principal.Subject))
+
+	logger := common.LoggerFromContext(ctx).With(zap.String("subject", principal.Subject))
+	ctx = common.ContextWithLogger(ctx, logger)
+
+	return ContextWithPrincipal(ctx, principal)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	Scopes   []string
+	Roles    []string
+	Tenant   string
+	Claims   map[string]any
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type key int
+
+const (
+	principalKey key = iota
+	tokenKey
+)
+
+// PrincipalFromContext returns nil when the request is not authenticated.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey).(*Principal)
+	return principal
+}
+
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey).(string)
+	return token, ok
+}
+
+func ContextWithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
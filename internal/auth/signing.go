@@ -0,0 +1,417 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gokit-seed/internal/common"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"go.uber.org/zap"
+)
+
+const (
+	SigningModeHmac   = "hmac"
+	SigningModeApiKey = "apikey"
+
+	HeaderApiKey         = "X-Api-Key"
+	HeaderSignature      = "X-Signature"
+	HeaderSignatureKeyId = "X-Signature-Key-Id"
+	HeaderTimestamp      = "X-Signature-Timestamp"
+	HeaderNonce          = "X-Signature-Nonce"
+	HeaderContentSha256  = "X-Content-Sha256"
+)
+
+// Keyring holds the shared keys used to authenticate service-to-service
+// calls. Requests are signed with the active key and verified against any key
+// of the ring, so keys can be rotated by adding the new key everywhere,
+// switching the active key, then removing the old one.
+// A nil *Keyring means signing is disabled.
+type Keyring struct {
+	mode        string
+	activeKeyId string
+	keys        map[string][]byte
+	scopes      []string
+	required    bool
+	maxSkew     time.Duration
+	maxBodySize int64
+	nonces      *nonceCache
+}
+
+// NewKeyring loads keys from SIGNING_KEYS ("id:secret,id:secret"). It returns
+// nil when SIGNING_KEYS is not set.
+func NewKeyring() (*Keyring, error) {
+	value := common.GetEnv("SIGNING_KEYS")
+
+	if value == nil {
+		return nil, nil
+	}
+
+	k := &Keyring{
+		mode:        SigningModeHmac,
+		keys:        map[string][]byte{},
+		required:    common.DefaultGetEnvBool("SIGNING_REQUIRED", false),
+		maxSkew:     common.DefaultGetEnvDuration("SIGNING_MAX_SKEW", 5*time.Minute),
+		maxBodySize: 10 << 20,
+	}
+
+	for _, pair := range strings.Split(*value, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid SIGNING_KEYS entry %q", pair)
+		}
+
+		if k.activeKeyId == "" {
+			k.activeKeyId = id
+		}
+
+		k.keys[id] = []byte(secret)
+	}
+
+	if id := os.Getenv("SIGNING_KEY_ID"); id != "" {
+		if _, ok := k.keys[id]; !ok {
+			return nil, fmt.Errorf("SIGNING_KEY_ID %q is not in SIGNING_KEYS", id)
+		}
+		k.activeKeyId = id
+	}
+
+	if mode := os.Getenv("SIGNING_MODE"); mode != "" {
+		if mode != SigningModeHmac && mode != SigningModeApiKey {
+			return nil, fmt.Errorf("invalid SIGNING_MODE %q", mode)
+		}
+		k.mode = mode
+	}
+
+	if scopes := common.GetEnv("SIGNING_SCOPES"); scopes != nil {
+		k.scopes = strings.Split(*scopes, ",")
+	}
+
+	// Nonces are kept for the whole window, sized for SIGNING_MAX_RATE signed
+	// requests per second.
+	maxRate := common.DefaultGetEnvInt("SIGNING_MAX_RATE", 500)
+	k.nonces = newNonceCache(k.maxSkew, maxRate*int(2*k.maxSkew/time.Second))
+	return k, nil
+}
+
+// WrapClient returns a copy of client whose requests are signed.
+func (k *Keyring) WrapClient(client *http.Client) *http.Client {
+	if k == nil {
+		return client
+	}
+
+	wrapped := *client
+	wrapped.Transport = k.Transport(client.Transport)
+	return &wrapped
+}
+
+func (k *Keyring) Transport(next http.RoundTripper) http.RoundTripper {
+	if k == nil {
+		return next
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &signingTransport{k, next}
+}
+
+type signingTransport struct {
+	k    *Keyring
+	next http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	signed := r.Clone(r.Context())
+
+	if t.k.mode == SigningModeApiKey {
+		signed.Header.Set(HeaderApiKey, string(t.k.keys[t.k.activeKeyId]))
+		return t.next.RoundTrip(signed)
+	}
+
+	var body []byte
+
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		signed.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(body)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signed.Header.Set(HeaderSignatureKeyId, t.k.activeKeyId)
+	signed.Header.Set(HeaderTimestamp, timestamp)
+	signed.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+	signed.Header.Set(HeaderContentSha256, hex.EncodeToString(digest[:]))
+	signed.Header.Set(HeaderSignature, t.k.sign(t.k.keys[t.k.activeKeyId], signed))
+
+	return t.next.RoundTrip(signed)
+}
+
+// sign computes the HMAC-SHA256 of the method, path, query, timestamp, nonce
+// and body digest of a request.
+func (k *Keyring) sign(key []byte, r *http.Request) string {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		r.Header.Get(HeaderTimestamp),
+		r.Header.Get(HeaderNonce),
+		r.Header.Get(HeaderContentSha256),
+	}, "\n"))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type withSignature struct {
+	k    *Keyring
+	next http.Handler
+}
+
+// VerifySignature is a common.HandleChain authenticating signed requests.
+// Valid callers get a service Principal carrying the SIGNING_SCOPES scopes,
+// which Verifier.Authenticate accepts in place of a bearer token. Unsigned
+// requests are passed through unless SIGNING_REQUIRED is true.
+func (k *Keyring) VerifySignature(next http.Handler) http.Handler {
+	if k == nil {
+		return next
+	}
+
+	return &withSignature{k, next}
+}
+
+func (h *withSignature) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	signed := r.Header.Get(HeaderSignature) != "" || r.Header.Get(HeaderApiKey) != ""
+
+	if !signed {
+		if h.k.required {
+			kithttp.DefaultErrorEncoder(ctx, ErrInvalidSignature(errors.New("request is not signed")), w)
+			return
+		}
+
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	keyId, err := h.k.verify(r)
+
+	if err != nil {
+		common.LoggerFromContext(ctx).Debug("rejected request signature", zap.Error(err))
+		kithttp.DefaultErrorEncoder(ctx, ErrInvalidSignature(err), w)
+		return
+	}
+
+	ctx = contextWithSubject(ctx, &Principal{
+		Subject: "service:" + keyId,
+		Scopes:  h.k.scopes,
+		Roles:   []string{"service"},
+	})
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// verify only accepts the configured mode, so that HMAC secrets never travel
+// as api keys and api keys never skip the checks of signed requests.
+func (k *Keyring) verify(r *http.Request) (string, error) {
+	if k.mode == SigningModeApiKey {
+		return k.verifyApiKey(r)
+	}
+
+	return k.verifyHmac(r)
+}
+
+func (k *Keyring) verifyApiKey(r *http.Request) (string, error) {
+	apiKey := r.Header.Get(HeaderApiKey)
+
+	if apiKey == "" {
+		return "", errors.New("missing api key")
+	}
+
+	for id, key := range k.keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), key) == 1 {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("unknown api key")
+}
+
+func (k *Keyring) verifyHmac(r *http.Request) (string, error) {
+	if r.Header.Get(HeaderSignature) == "" {
+		return "", errors.New("missing signature")
+	}
+
+	keyId := r.Header.Get(HeaderSignatureKeyId)
+	key, ok := k.keys[keyId]
+
+	if !ok {
+		return "", fmt.Errorf("unknown key id %q", keyId)
+	}
+
+	unix, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+
+	if err != nil {
+		return "", errors.New("invalid timestamp")
+	}
+
+	signedAt := time.Unix(unix, 0)
+
+	if skew := time.Since(signedAt); skew > k.maxSkew || skew < -k.maxSkew {
+		return "", errors.New("timestamp outside of the accepted window")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, k.maxBodySize+1))
+	r.Body.Close()
+
+	if err != nil {
+		return "", err
+	}
+
+	if int64(len(body)) > k.maxBodySize {
+		return "", errors.New("body too large")
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	digest := sha256.Sum256(body)
+
+	if !hmac.Equal([]byte(hex.EncodeToString(digest[:])), []byte(r.Header.Get(HeaderContentSha256))) {
+		return "", errors.New("body digest mismatch")
+	}
+
+	expected := k.sign(key, r)
+
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return "", errors.New("signature mismatch")
+	}
+
+	// Only checked once the signature is known to be valid, so that forged
+	// requests cannot burn nonces.
+	if !k.nonces.add(keyId+":"+r.Header.Get(HeaderNonce), signedAt) {
+		return "", errors.New("replayed nonce")
+	}
+
+	return keyId, nil
+}
+
+func ErrInvalidSignature(cause error) *Error {
+	return &Error{
+		Code:    http.StatusUnauthorized,
+		Reason:  "invalid_signature",
+		Message: "invalid request signature",
+		cause:   cause,
+	}
+}
+
+// nonceBuckets is how many buckets span the accepted timestamp window.
+const nonceBuckets = 20
+
+// nonceCache remembers nonces by the time they were signed at, until that time
+// leaves the accepted window. A replay carries the signed timestamp, so it is
+// looked up in a single bucket, and buckets expire as a whole. When full, the
+// oldest bucket is evicted and requests signed before its end are refused, so
+// that no nonce can be replayed once forgotten. Only a single bucket outgrowing
+// the cache, at nonceBuckets times the rate it is sized for, refuses nonces.
+type nonceCache struct {
+	mu      sync.Mutex
+	maxSkew time.Duration
+	width   int64
+	maxSize int
+	size    int
+	buckets map[int64]map[string]struct{}
+	// floor is the first bucket still accepted after evictions.
+	floor int64
+}
+
+func newNonceCache(maxSkew time.Duration, maxSize int) *nonceCache {
+	return &nonceCache{
+		maxSkew: maxSkew,
+		width:   int64(max(2*maxSkew/nonceBuckets, time.Second)),
+		maxSize: max(maxSize, 1),
+		buckets: map[int64]map[string]struct{}{},
+	}
+}
+
+// add returns false if the nonce was already seen with signedAt, or if nonces
+// signed then may have been evicted.
+func (c *nonceCache) add(nonce string, signedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(time.Now())
+	key := signedAt.UnixNano() / c.width
+
+	if _, ok := c.buckets[key][nonce]; ok || key < c.floor {
+		return false
+	}
+
+	for c.size >= c.maxSize {
+		c.evictOldest()
+	}
+
+	if key < c.floor {
+		return false
+	}
+
+	bucket, ok := c.buckets[key]
+
+	if !ok {
+		bucket = map[string]struct{}{}
+		c.buckets[key] = bucket
+	}
+
+	bucket[nonce] = struct{}{}
+	c.size++
+	return true
+}
+
+// expire drops the buckets whose timestamps are all out of the window.
+func (c *nonceCache) expire(now time.Time) {
+	oldest := now.Add(-c.maxSkew).UnixNano() / c.width
+
+	for key, bucket := range c.buckets {
+		if key < oldest {
+			c.size -= len(bucket)
+			delete(c.buckets, key)
+		}
+	}
+}
+
+func (c *nonceCache) evictOldest() {
+	oldest, first := int64(0), true
+
+	for key := range c.buckets {
+		if first || key < oldest {
+			oldest, first = key, false
+		}
+	}
+
+	c.size -= len(c.buckets[oldest])
+	delete(c.buckets, oldest)
+	c.floor = max(c.floor, oldest+1)
+}
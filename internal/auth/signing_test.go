@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeyring(t *testing.T, mode string) *Keyring {
+	t.Helper()
+	t.Setenv("SIGNING_KEYS", "k1:first-secret,k2:second-secret")
+	t.Setenv("SIGNING_MODE", mode)
+	t.Setenv("SIGNING_REQUIRED", "true")
+
+	k, err := NewKeyring()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+// recordingTransport keeps the signed request instead of sending it.
+type recordingTransport struct {
+	request *http.Request
+	body    string
+}
+
+func (t *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(r.Body)
+	t.request, t.body = r, string(body)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+func sign(t *testing.T, k *Keyring, body string) (*http.Request, string) {
+	t.Helper()
+
+	recorder := &recordingTransport{}
+	client := &http.Client{Transport: k.Transport(recorder)}
+
+	if _, err := client.Post("http://upstream/strings/reversions?x=1", "application/json", strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder.request, recorder.body
+}
+
+// serve replays a signed request against VerifySignature.
+func serve(k *Keyring, signed *http.Request, body string) int {
+	r := httptest.NewRequest(signed.Method, signed.URL.String(), strings.NewReader(body))
+	r.Header = signed.Header.Clone()
+	w := httptest.NewRecorder()
+
+	k.VerifySignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if PrincipalFromContext(r.Context()) == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})).ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func TestSignedRequestIsVerifiedOnce(t *testing.T) {
+	k := newTestKeyring(t, SigningModeHmac)
+	signed, body := sign(t, k, `{"value":"abc"}`)
+
+	if code := serve(k, signed, body); code != http.StatusOK {
+		t.Fatalf("signed request got %d", code)
+	}
+
+	if code := serve(k, signed, body); code != http.StatusUnauthorized {
+		t.Fatalf("replayed request got %d, want 401", code)
+	}
+}
+
+func TestTamperedRequestIsRejected(t *testing.T) {
+	k := newTestKeyring(t, SigningModeHmac)
+	signed, _ := sign(t, k, `{"value":"abc"}`)
+
+	if code := serve(k, signed, `{"value":"abd"}`); code != http.StatusUnauthorized {
+		t.Fatalf("tampered body got %d, want 401", code)
+	}
+
+	signed, body := sign(t, k, `{"value":"abc"}`)
+	signed.Header.Set(HeaderTimestamp, "1")
+
+	if code := serve(k, signed, body); code != http.StatusUnauthorized {
+		t.Fatalf("tampered timestamp got %d, want 401", code)
+	}
+}
+
+func TestOnlyTheConfiguredModeIsAccepted(t *testing.T) {
+	hmacKeyring := newTestKeyring(t, SigningModeHmac)
+	apiKey := httptest.NewRequest(http.MethodGet, "/", nil)
+	apiKey.Header.Set(HeaderApiKey, "first-secret")
+
+	if code := serve(hmacKeyring, apiKey, ""); code != http.StatusUnauthorized {
+		t.Fatalf("api key in hmac mode got %d, want 401", code)
+	}
+
+	apiKeyring := newTestKeyring(t, SigningModeApiKey)
+
+	if code := serve(apiKeyring, apiKey, ""); code != http.StatusOK {
+		t.Fatalf("api key in apikey mode got %d", code)
+	}
+
+	signed, body := sign(t, hmacKeyring, "")
+
+	if code := serve(apiKeyring, signed, body); code != http.StatusUnauthorized {
+		t.Fatalf("signature in apikey mode got %d, want 401", code)
+	}
+}
+
+func TestNonceCacheEvictsInsteadOfRefusing(t *testing.T) {
+	// Buckets of 10s.
+	c := newNonceCache(100*time.Second, 10)
+	now := time.Now()
+	oldest, older := now.Add(-90*time.Second), now.Add(-50*time.Second)
+
+	for i := 0; i < 5; i++ {
+		c.add(fmt.Sprint("oldest", i), oldest)
+		c.add(fmt.Sprint("older", i), older)
+	}
+
+	for i := 0; i < 5; i++ {
+		if !c.add(fmt.Sprint("new", i), now) {
+			t.Fatalf("nonce %d refused once the cache is full", i)
+		}
+	}
+
+	if c.size > c.maxSize {
+		t.Fatalf("cache holds %d nonces, more than %d", c.size, c.maxSize)
+	}
+
+	// The oldest bucket is forgotten, so requests signed then are refused
+	// even with a new nonce.
+	if c.add("oldest9", oldest) {
+		t.Fatal("nonce signed before the evicted bucket accepted")
+	}
+
+	if c.add("older0", older) || c.add("new0", now) {
+		t.Fatal("replayed nonce accepted")
+	}
+}
+
+func TestNonceCacheExpiresBuckets(t *testing.T) {
+	c := newNonceCache(10*time.Second, 100)
+	c.add("a", time.Now().Add(-time.Minute))
+	c.add("b", time.Now())
+
+	c.mu.Lock()
+	c.expire(time.Now())
+	size := c.size
+	c.mu.Unlock()
+
+	if size != 1 {
+		t.Fatalf("cache holds %d nonces after expiry, want 1", size)
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/otel"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var defaultAlgorithms = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+}
+
+// Verifier validates JWT bearer tokens and turns their claims into a Principal.
+// A nil *Verifier means authentication is disabled; its middlewares then let
+// every request through.
+type Verifier struct {
+	keys   *KeySet
+	secret []byte
+	parser *jwt.Parser
+}
+
+// NewVerifier configures token validation from the environment. Keys come from
+// AUTH_JWKS_URL, AUTH_JWKS_FILE or a shared AUTH_HMAC_SECRET; when none is set
+// authentication is disabled and nil is returned.
+func NewVerifier() (*Verifier, error) {
+	refreshInterval := common.DefaultGetEnvDuration("AUTH_JWKS_REFRESH_INTERVAL", 5*time.Minute)
+	v := &Verifier{}
+
+	switch {
+	case os.Getenv("AUTH_JWKS_URL") != "":
+		v.keys = NewUrlKeySet(os.Getenv("AUTH_JWKS_URL"), otel.DefaultClient, refreshInterval)
+	case os.Getenv("AUTH_JWKS_FILE") != "":
+		v.keys = NewFileKeySet(os.Getenv("AUTH_JWKS_FILE"), refreshInterval)
+	case os.Getenv("AUTH_HMAC_SECRET") != "":
+		v.secret = []byte(os.Getenv("AUTH_HMAC_SECRET"))
+	default:
+		return nil, nil
+	}
+
+	algorithms := defaultAlgorithms
+	if value := common.GetEnv("AUTH_ALGORITHMS"); value != nil {
+		algorithms = strings.Split(*value, ",")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithLeeway(common.DefaultGetEnvDuration("AUTH_CLOCK_SKEW", 30*time.Second)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+
+	if issuer := common.GetEnv("AUTH_ISSUER"); issuer != nil {
+		opts = append(opts, jwt.WithIssuer(*issuer))
+	}
+
+	if audience := common.GetEnv("AUTH_AUDIENCE"); audience != nil {
+		opts = append(opts, jwt.WithAudience(*audience))
+	}
+
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Verify validates the signature and registered claims of a raw token.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (any, error) {
+		if v.keys == nil {
+			return v.secret, nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+
+	if err != nil {
+		return nil, ErrInvalidToken(err)
+	}
+
+	return newPrincipal(claims)
+}
+
+func newPrincipal(claims jwt.MapClaims) (*Principal, error) {
+	subject, err := claims.GetSubject()
+
+	if err != nil || subject == "" {
+		return nil, ErrInvalidToken(fmt.Errorf("missing subject"))
+	}
+
+	issuer, _ := claims.GetIssuer()
+	audience, _ := claims.GetAudience()
+
+	principal := &Principal{
+		Subject:  subject,
+		Issuer:   issuer,
+		Audience: audience,
+		Roles:    stringList(claims["roles"]),
+		Claims:   claims,
+	}
+
+	// OAuth2 uses a space separated "scope" claim, some providers use an "scp" array.
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Scopes = strings.Fields(scope)
+	} else {
+		principal.Scopes = stringList(claims["scp"])
+	}
+
+	if tenant, ok := claims["tenant"].(string); ok {
+		principal.Tenant = tenant
+	} else if tenant, ok := claims["tid"].(string); ok {
+		principal.Tenant = tenant
+	}
+
+	return principal, nil
+}
+
+func stringList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+
+	return nil
+}
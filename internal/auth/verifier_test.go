@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKey signs tokens and describes its public part as a JSON Web Key.
+type testKey struct {
+	method jwt.SigningMethod
+	signer any
+	jwk    map[string]string
+}
+
+func newRsaKey(t *testing.T, kid string) testKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{jwt.SigningMethodRS256, key, map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}
+}
+
+func newEcKey(t *testing.T, kid string) testKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{jwt.SigningMethodES256, key, map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}
+}
+
+func newHmacKey(kid string, secret string) testKey {
+	return testKey{jwt.SigningMethodHS256, []byte(secret), map[string]string{
+		"kty": "oct",
+		"kid": kid,
+		"k":   base64.RawURLEncoding.EncodeToString([]byte(secret)),
+	}}
+}
+
+func jwks(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+
+	set := map[string][]map[string]string{"keys": {}}
+	for _, key := range keys {
+		set["keys"] = append(set["keys"], key.jwk)
+	}
+
+	data, err := json.Marshal(set)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func (k testKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(k.method, claims)
+
+	if kid := k.jwk["kid"]; kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(k.signer)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.test",
+		"aud":   "strings",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"scope": "strings:read strings:reverse",
+		"roles": []string{"admin"},
+		"tid":   "acme",
+	}
+}
+
+func newTestVerifier(t *testing.T, env map[string]string) *Verifier {
+	t.Helper()
+
+	for _, name := range []string{"AUTH_JWKS_URL", "AUTH_JWKS_FILE", "AUTH_HMAC_SECRET", "AUTH_ISSUER", "AUTH_AUDIENCE", "AUTH_CLOCK_SKEW", "AUTH_ALGORITHMS"} {
+		t.Setenv(name, env[name])
+	}
+
+	v, err := NewVerifier()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestVerifyChecksRegisteredClaims(t *testing.T) {
+	key := newHmacKey("", "shared-secret")
+	v := newTestVerifier(t, map[string]string{
+		"AUTH_HMAC_SECRET": "shared-secret",
+		"AUTH_ISSUER":      "https://issuer.test",
+		"AUTH_AUDIENCE":    "strings",
+		"AUTH_CLOCK_SKEW":  "30s",
+	})
+
+	with := func(name string, value any) jwt.MapClaims {
+		claims := validClaims()
+
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+
+		return claims
+	}
+
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{"valid", validClaims(), true},
+		{"other issuer", with("iss", "https://other.test"), false},
+		{"other audience", with("aud", "users"), false},
+		{"audience list", with("aud", []string{"users", "strings"}), true},
+		{"expired", with("exp", now.Add(-time.Minute).Unix()), false},
+		{"expired within the clock skew", with("exp", now.Add(-10*time.Second).Unix()), true},
+		{"issued in the future", with("iat", now.Add(time.Minute).Unix()), false},
+		{"issued within the clock skew", with("iat", now.Add(10*time.Second).Unix()), true},
+		{"not yet valid", with("nbf", now.Add(time.Minute).Unix()), false},
+		{"no expiry", with("exp", nil), false},
+		{"no subject", with("sub", nil), false},
+	} {
+		_, err := v.Verify(context.Background(), key.sign(t, tc.claims))
+
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+
+		var authErr *Error
+		if err != nil && (!errors.As(err, &authErr) || authErr.Code != http.StatusUnauthorized) {
+			t.Errorf("%s: %v is not a 401", tc.name, err)
+		}
+	}
+
+	// Tokens signed with another secret or algorithm are rejected.
+	if _, err := v.Verify(context.Background(), newHmacKey("", "other-secret").sign(t, validClaims())); err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	if _, err := v.Verify(context.Background(), unsigned); err == nil {
+		t.Error("unsigned token accepted")
+	}
+}
+
+func TestVerifyBuildsThePrincipal(t *testing.T) {
+	v := newTestVerifier(t, map[string]string{"AUTH_HMAC_SECRET": "shared-secret"})
+
+	principal, err := v.Verify(context.Background(), newHmacKey("", "shared-secret").sign(t, validClaims()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if principal.Subject != "user-1" || principal.Issuer != "https://issuer.test" || principal.Tenant != "acme" ||
+		!slices.Equal(principal.Audience, []string{"strings"}) ||
+		!slices.Equal(principal.Scopes, []string{"strings:read", "strings:reverse"}) ||
+		!principal.HasRole("admin") {
+		t.Fatalf("principal %+v", principal)
+	}
+
+	claims := validClaims()
+	delete(claims, "scope")
+	claims["scp"] = []string{"strings:read"}
+
+	if principal, _ := v.Verify(context.Background(), newHmacKey("", "shared-secret").sign(t, claims)); !principal.HasScope("strings:read") {
+		t.Fatalf("scp claim ignored: %v", principal.Scopes)
+	}
+}
+
+func TestVerifyWithJwksFile(t *testing.T) {
+	rsaKey, ecKey, hmacKey := newRsaKey(t, "rsa"), newEcKey(t, "ec"), newHmacKey("oct", "jwks-secret")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+
+	if err := os.WriteFile(path, jwks(t, rsaKey, ecKey, hmacKey), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTestVerifier(t, map[string]string{"AUTH_JWKS_FILE": path})
+
+	for _, key := range []testKey{rsaKey, ecKey, hmacKey} {
+		if _, err := v.Verify(context.Background(), key.sign(t, validClaims())); err != nil {
+			t.Errorf("%s token: %v", key.method.Alg(), err)
+		}
+	}
+
+	// A known key id with a key of another owner.
+	forged := newRsaKey(t, "rsa")
+
+	if _, err := v.Verify(context.Background(), forged.sign(t, validClaims())); err == nil {
+		t.Error("token signed with an unknown key accepted")
+	}
+
+	// Algorithms can be restricted.
+	v = newTestVerifier(t, map[string]string{"AUTH_JWKS_FILE": path, "AUTH_ALGORITHMS": "ES256"})
+
+	if _, err := v.Verify(context.Background(), rsaKey.sign(t, validClaims())); err == nil {
+		t.Error("RS256 token accepted with ES256 only")
+	}
+}
+
+func TestNewParserValidatesTheContextToken(t *testing.T) {
+	key := newHmacKey("", "shared-secret")
+	v := newTestVerifier(t, map[string]string{"AUTH_HMAC_SECRET": "shared-secret"})
+
+	var principal *Principal
+	parse := v.NewParser()(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		principal = PrincipalFromContext(ctx)
+		return nil, nil
+	})
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+key.sign(t, validClaims()))
+	ctx := HTTPToContext()(context.Background(), r)
+
+	if _, err := parse(ctx, nil); err != nil || principal == nil || principal.Subject != "user-1" {
+		t.Fatalf("valid token got %v, principal %v", err, principal)
+	}
+
+	if _, err := parse(context.Background(), nil); err != ErrMissingToken {
+		t.Fatalf("missing token got %v", err)
+	}
+
+	if _, err := parse(ContextWithToken(context.Background(), "not.a.token"), nil); err == nil {
+		t.Fatal("invalid token accepted")
+	}
+
+	// Already authenticated callers, such as signed requests, go through.
+	service := &Principal{Subject: "service:k1"}
+
+	if _, err := parse(ContextWithPrincipal(context.Background(), service), nil); err != nil || principal != service {
+		t.Fatalf("authenticated caller got %v", err)
+	}
+
+	// Disabled verifiers let everything through.
+	var disabled *Verifier
+
+	if _, err := disabled.NewParser()(endpoint.Nop)(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,332 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd/lb"
+)
+
+// Strategy creates a load balancer over the instances of an Endpointer.
+type Strategy func(e *Endpointer) lb.Balancer
+
+// ParseStrategy returns the strategy called name: "round_robin" (default),
+// "random", "least_outstanding", "p2c" or "consistent_hash".
+func ParseStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", "round_robin":
+		return RoundRobin, nil
+	case "random":
+		return Random, nil
+	case "least_outstanding":
+		return NewLeastOutstanding, nil
+	case "p2c":
+		return NewP2C, nil
+	case "consistent_hash":
+		return func(e *Endpointer) lb.Balancer { return NewConsistentHash(e, 100) }, nil
+	}
+
+	return nil, fmt.Errorf("unknown load balancing strategy %q", name)
+}
+
+type attemptsContextKey struct{}
+
+// attempts are the instances a call was sent to.
+type attempts struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// ContextWithAttempts tracks the instances calls made with the returned
+// context are sent to, so that balancers send retries and hedges of a call to
+// other instances while there are any. It keeps the tracking of ctx if set.
+func ContextWithAttempts(ctx context.Context) context.Context {
+	if attemptsFromContext(ctx) != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, attemptsContextKey{}, &attempts{names: map[string]bool{}})
+}
+
+func attemptsFromContext(ctx context.Context) *attempts {
+	a, _ := ctx.Value(attemptsContextKey{}).(*attempts)
+	return a
+}
+
+func (a *attempts) add(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.names[name] = true
+}
+
+func (a *attempts) tried(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.names[name]
+}
+
+// untried returns the instances the call of ctx was not sent to yet, or all of
+// them once every one was tried.
+func untried(ctx context.Context, instances []*Instance) []*Instance {
+	a := attemptsFromContext(ctx)
+
+	if a == nil {
+		return instances
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.names) == 0 {
+		return instances
+	}
+
+	candidates := make([]*Instance, 0, len(instances))
+
+	for _, instance := range instances {
+		if !a.names[instance.Name] {
+			candidates = append(candidates, instance)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return instances
+	}
+
+	return candidates
+}
+
+// picker chooses one of candidates, which is never empty.
+type picker func(ctx context.Context, candidates []*Instance) *Instance
+
+// lazyBalancer picks the instance when its endpoint is called, as the
+// instances already tried are only known from the request context.
+type lazyBalancer struct {
+	e    *Endpointer
+	pick picker
+}
+
+func (b lazyBalancer) Endpoint() (endpoint.Endpoint, error) {
+	if err := b.available(); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		instances, err := b.e.Instances()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(instances) == 0 {
+			return nil, lb.ErrNoEndpoints
+		}
+
+		return b.pick(ctx, untried(ctx, instances)).Endpoint(ctx, request)
+	}, nil
+}
+
+func (b lazyBalancer) available() error {
+	instances, err := b.e.Instances()
+
+	if err != nil {
+		return err
+	}
+
+	if len(instances) == 0 {
+		return lb.ErrNoEndpoints
+	}
+
+	return nil
+}
+
+func RoundRobin(e *Endpointer) lb.Balancer {
+	var counter atomic.Uint64
+
+	return lazyBalancer{e, func(_ context.Context, candidates []*Instance) *Instance {
+		return candidates[(counter.Add(1)-1)%uint64(len(candidates))]
+	}}
+}
+
+func Random(e *Endpointer) lb.Balancer {
+	return lazyBalancer{e, func(_ context.Context, candidates []*Instance) *Instance {
+		return candidates[rand.IntN(len(candidates))]
+	}}
+}
+
+// NewLeastOutstanding picks the instance with the fewest calls in progress,
+// at random among ties.
+func NewLeastOutstanding(e *Endpointer) lb.Balancer {
+	return lazyBalancer{e, leastOutstanding}
+}
+
+func leastOutstanding(_ context.Context, candidates []*Instance) *Instance {
+	var (
+		best  *Instance
+		least int64
+		ties  int
+	)
+
+	for _, instance := range candidates {
+		n := instance.Inflight()
+
+		switch {
+		case best == nil || n < least:
+			best, least, ties = instance, n, 1
+		case n == least:
+			// Reservoir sampling keeps each tie equally likely.
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = instance
+			}
+		}
+	}
+
+	return best
+}
+
+// NewP2C picks two instances at random and keeps the one with the lowest
+// expected latency, its moving average latency weighted by the calls in
+// progress.
+func NewP2C(e *Endpointer) lb.Balancer {
+	return lazyBalancer{e, p2c}
+}
+
+func p2c(_ context.Context, candidates []*Instance) *Instance {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+
+	if j >= i {
+		j++
+	}
+
+	a, c := candidates[i], candidates[j]
+
+	if cost(c) < cost(a) {
+		a = c
+	}
+
+	return a
+}
+
+func cost(i *Instance) float64 {
+	// Unprobed instances look fast so that they get traffic.
+	return float64(i.Latency()+1) * float64(i.Inflight()+1)
+}
+
+type keyContextKey struct{}
+
+// ContextWithKey sets the key consistent hashing routes the request with.
+func ContextWithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+func KeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(keyContextKey{}).(string)
+	return key
+}
+
+type consistentHash struct {
+	e        *Endpointer
+	replicas int
+
+	mu      sync.Mutex
+	version uint64
+	points  []uint32
+	owners  map[uint32]*Instance
+}
+
+// NewConsistentHash routes requests with the same key, see ContextWithKey, to
+// the same instance as long as it is available, so that instance caches stay
+// warm. Each instance gets replicas points on the ring. Retries and hedges go
+// to the next instances on the ring. Requests without a key go to a random
+// instance.
+func NewConsistentHash(e *Endpointer, replicas int) lb.Balancer {
+	return &consistentHash{e: e, replicas: replicas}
+}
+
+// Endpoint returns an endpoint choosing the instance when called, as the key
+// is only known from the request context.
+func (b *consistentHash) Endpoint() (endpoint.Endpoint, error) {
+	if _, err := b.e.Instances(); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		instance, err := b.pick(ctx, KeyFromContext(ctx))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return instance.Endpoint(ctx, request)
+	}, nil
+}
+
+func (b *consistentHash) pick(ctx context.Context, key string) (*Instance, error) {
+	instances, version, err := b.e.snapshot()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(instances) == 0 {
+		return nil, lb.ErrNoEndpoints
+	}
+
+	if key == "" {
+		candidates := untried(ctx, instances)
+		return candidates[rand.IntN(len(candidates))], nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.points == nil || version != b.version {
+		b.rebuild(instances, version)
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(b.points), func(i int) bool { return b.points[i] >= hash })
+	a := attemptsFromContext(ctx)
+
+	// Walk the ring past the instances already tried.
+	for n := 0; n < len(b.points); n++ {
+		owner := b.owners[b.points[(i+n)%len(b.points)]]
+
+		if a == nil || !a.tried(owner.Name) {
+			return owner, nil
+		}
+	}
+
+	return b.owners[b.points[i%len(b.points)]], nil
+}
+
+func (b *consistentHash) rebuild(instances []*Instance, version uint64) {
+	b.points = make([]uint32, 0, len(instances)*b.replicas)
+	b.owners = make(map[uint32]*Instance, len(instances)*b.replicas)
+
+	for _, instance := range instances {
+		for r := 0; r < b.replicas; r++ {
+			point := crc32.ChecksumIEEE([]byte(instance.Name + "#" + strconv.Itoa(r)))
+			b.points = append(b.points, point)
+			b.owners[point] = instance
+		}
+	}
+
+	sort.Slice(b.points, func(i, j int) bool { return b.points[i] < b.points[j] })
+	b.version = version
+}
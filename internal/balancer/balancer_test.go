@@ -0,0 +1,175 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+var names = []string{"a", "b", "c"}
+
+func newTestEndpointer(t *testing.T) *Endpointer {
+	t.Helper()
+
+	e := NewEndpointer("test", sd.FixedInstancer(names), func(name string) (endpoint.Endpoint, io.Closer, error) {
+		return func(context.Context, interface{}) (interface{}, error) {
+			return name, nil
+		}, nil, nil
+	}, zap.NewNop())
+	t.Cleanup(e.Close)
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if instances, _ := e.Instances(); len(instances) == len(names) {
+			return e
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("instances not discovered")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func call(t *testing.T, ctx context.Context, b interface {
+	Endpoint() (endpoint.Endpoint, error)
+}) string {
+	t.Helper()
+
+	e, err := b.Endpoint()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := e(ctx, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response.(string)
+}
+
+func TestConsistentHashKeepsKeysOnAnInstance(t *testing.T) {
+	b := NewConsistentHash(newTestEndpointer(t), 100)
+	ctx := ContextWithKey(context.Background(), "tenant:acme")
+	first := call(t, ctx, b)
+
+	for i := 0; i < 10; i++ {
+		if got := call(t, ctx, b); got != first {
+			t.Fatalf("key moved from %s to %s", first, got)
+		}
+	}
+}
+
+func TestConsistentHashRetriesOnTheNextInstance(t *testing.T) {
+	b := NewConsistentHash(newTestEndpointer(t), 100)
+	ctx := ContextWithAttempts(ContextWithKey(context.Background(), "tenant:acme"))
+	seen := map[string]bool{}
+
+	for range names {
+		got := call(t, ctx, b)
+
+		if seen[got] {
+			t.Fatalf("%s picked again while other instances were untried", got)
+		}
+
+		seen[got] = true
+	}
+
+	// Once every instance was tried, the owner of the key is picked again.
+	if got := call(t, ContextWithKey(context.Background(), "tenant:acme"), b); !seen[got] {
+		t.Fatalf("unexpected instance %s", got)
+	}
+}
+
+func TestStrategiesSkipTriedInstances(t *testing.T) {
+	e := newTestEndpointer(t)
+
+	for name, strategy := range map[string]Strategy{
+		"round_robin":       RoundRobin,
+		"random":            Random,
+		"least_outstanding": NewLeastOutstanding,
+		"p2c":               NewP2C,
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := strategy(e)
+
+			for i := 0; i < 20; i++ {
+				ctx := ContextWithAttempts(context.Background())
+				seen := map[string]bool{}
+
+				for range names {
+					got := call(t, ctx, b)
+
+					if seen[got] {
+						t.Fatalf("%s picked twice for one call", got)
+					}
+
+					seen[got] = true
+				}
+			}
+		})
+	}
+}
+
+func TestContextWithAttemptsKeepsTracking(t *testing.T) {
+	ctx := ContextWithAttempts(context.Background())
+
+	if ContextWithAttempts(ctx) != ctx {
+		t.Fatal("tracking replaced")
+	}
+}
+
+func TestEmptyEndpointerHasNoEndpoints(t *testing.T) {
+	e := NewEndpointer("test", sd.FixedInstancer(nil), func(string) (endpoint.Endpoint, io.Closer, error) {
+		return nil, nil, errors.New("unused")
+	}, zap.NewNop())
+	defer e.Close()
+
+	if _, err := RoundRobin(e).Endpoint(); err == nil {
+		t.Fatal("endpoint returned without instances")
+	}
+}
+
+func TestRefusedCallsAreNotTracked(t *testing.T) {
+	errRefused := errors.New("refused")
+	refuse := true
+	instance := &Instance{Name: "a"}
+	instance.Endpoint = track(instance, func(context.Context, interface{}) (interface{}, error) {
+		if refuse {
+			return nil, errRefused
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	}, func(err error) bool { return errors.Is(err, errRefused) })
+
+	ctx := ContextWithAttempts(context.Background())
+
+	if _, err := instance.Endpoint(ctx, nil); err != errRefused {
+		t.Fatal(err)
+	}
+
+	if !attemptsFromContext(ctx).tried("a") {
+		t.Fatal("refused call not counted as an attempt")
+	}
+
+	if instance.Latency() != 0 || instance.Inflight() != 0 {
+		t.Fatalf("refused call tracked: latency %s, %d in flight", instance.Latency(), instance.Inflight())
+	}
+
+	refuse = false
+	instance.Endpoint(context.Background(), nil)
+
+	if instance.Latency() < 10*time.Millisecond {
+		t.Fatalf("latency %s", instance.Latency())
+	}
+}
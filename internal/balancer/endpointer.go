@@ -0,0 +1,233 @@
+package balancer
+
+import (
+	"context"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	kitzap "github.com/go-kit/kit/log/zap"
+	"github.com/go-kit/kit/sd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// decay is the time constant of the latency moving average.
+const decay = 10 * time.Second
+
+// Instance is an endpoint along with the name it was discovered as and the
+// load statistics balancers pick from.
+type Instance struct {
+	Name     string
+	Endpoint endpoint.Endpoint
+
+	attrs    metric.MeasurementOption
+	inflight atomic.Int64
+
+	mu       sync.Mutex
+	ewma     float64 // seconds
+	lastSeen time.Time
+}
+
+// Inflight returns the number of calls in progress.
+func (i *Instance) Inflight() int64 {
+	return i.inflight.Load()
+}
+
+// Latency returns the exponentially weighted moving average latency.
+func (i *Instance) Latency() time.Duration {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return time.Duration(i.ewma * float64(time.Second))
+}
+
+func (i *Instance) observe(latency time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+
+	if i.lastSeen.IsZero() {
+		i.ewma = latency.Seconds()
+	} else {
+		w := math.Exp(-float64(now.Sub(i.lastSeen)) / float64(decay))
+		i.ewma = i.ewma*w + latency.Seconds()*(1-w)
+	}
+
+	i.lastSeen = now
+}
+
+// Endpointer is an sd.Endpointer which also exposes the instances behind its
+// endpoints, tracking their in-flight calls and latency.
+type Endpointer struct {
+	endpointer *sd.DefaultEndpointer
+	refused    func(error) bool
+
+	mu        sync.RWMutex
+	instances map[string]*Instance
+	sorted    []*Instance
+	version   uint64
+}
+
+// EndpointerOption configures an Endpointer.
+type EndpointerOption func(*Endpointer)
+
+// WithRefused sets the errors of calls the endpoints of the factory refused
+// without sending them, such as those of an open circuit breaker. These calls
+// still count as attempts but are left out of the load statistics, their near
+// zero latency making the instance look fast.
+func WithRefused(refused func(error) bool) EndpointerOption {
+	return func(e *Endpointer) {
+		e.refused = refused
+	}
+}
+
+// NewEndpointer creates endpoints for the instances of upstream using factory,
+// like sd.NewEndpointer.
+func NewEndpointer(upstream string, instancer sd.Instancer, factory sd.Factory, logger *zap.Logger, opts ...EndpointerOption) *Endpointer {
+	registerOnce.Do(registerMetrics)
+
+	e := &Endpointer{
+		instances: map[string]*Instance{},
+		refused:   func(error) bool { return false },
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	e.endpointer = sd.NewEndpointer(instancer, func(name string) (endpoint.Endpoint, io.Closer, error) {
+		next, closer, err := factory(name)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		instance := &Instance{
+			Name: name,
+			attrs: metric.WithAttributes(
+				attribute.String("upstream", upstream),
+				attribute.String("instance", name),
+			),
+		}
+		instance.Endpoint = track(instance, next, e.refused)
+		e.add(instance)
+
+		return instance.Endpoint, closerFunc(func() error {
+			e.remove(name)
+
+			if closer != nil {
+				return closer.Close()
+			}
+
+			return nil
+		}), nil
+	}, kitzap.NewZapSugarLogger(logger, zap.DebugLevel))
+
+	return e
+}
+
+func (e *Endpointer) add(instance *Instance) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.instances[instance.Name] = instance
+	e.reindex()
+}
+
+func (e *Endpointer) remove(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.instances, name)
+	e.reindex()
+}
+
+func (e *Endpointer) reindex() {
+	e.sorted = make([]*Instance, 0, len(e.instances))
+
+	for _, instance := range e.instances {
+		e.sorted = append(e.sorted, instance)
+	}
+
+	sort.Slice(e.sorted, func(i, j int) bool {
+		return e.sorted[i].Name < e.sorted[j].Name
+	})
+
+	e.version++
+}
+
+// Instances returns the current instances ordered by name. The slice must not
+// be modified.
+func (e *Endpointer) Instances() ([]*Instance, error) {
+	instances, _, err := e.snapshot()
+	return instances, err
+}
+
+// snapshot also returns a version changing along with the instances.
+func (e *Endpointer) snapshot() ([]*Instance, uint64, error) {
+	if _, err := e.endpointer.Endpoints(); err != nil {
+		return nil, 0, err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.sorted, e.version, nil
+}
+
+// Endpoints implements sd.Endpointer.
+func (e *Endpointer) Endpoints() ([]endpoint.Endpoint, error) {
+	return e.endpointer.Endpoints()
+}
+
+// Close deregisters from the instancer and closes every endpoint.
+func (e *Endpointer) Close() {
+	e.endpointer.Close()
+}
+
+func track(instance *Instance, next endpoint.Endpoint, refused func(error) bool) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if a := attemptsFromContext(ctx); a != nil {
+			a.add(instance.Name)
+		}
+
+		instance.inflight.Add(1)
+		inflight.Add(ctx, 1, instance.attrs)
+		start := time.Now()
+
+		response, err := next(ctx, request)
+
+		latency := time.Since(start)
+		instance.inflight.Add(-1)
+		inflight.Add(ctx, -1, instance.attrs)
+
+		if err != nil && refused(err) {
+			return response, err
+		}
+
+		instance.observe(latency)
+
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+
+		requests.Add(ctx, 1, instance.attrs, metric.WithAttributes(attribute.String("outcome", outcome)))
+		durations.Record(ctx, latency.Seconds(), instance.attrs)
+
+		return response, err
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "gokit-seed/balancer"
+
+var (
+	registerOnce sync.Once
+	requests     metric.Int64Counter
+	inflight     metric.Int64UpDownCounter
+	durations    metric.Float64Histogram
+)
+
+func registerMetrics() {
+	meter := otel.Meter(meterName)
+
+	requests, _ = meter.Int64Counter(
+		"balancer.requests",
+		metric.WithDescription("Calls sent to each upstream instance"),
+	)
+
+	inflight, _ = meter.Int64UpDownCounter(
+		"balancer.inflight",
+		metric.WithDescription("Calls in progress on each upstream instance"),
+	)
+
+	durations, _ = meter.Float64Histogram(
+		"balancer.duration",
+		metric.WithDescription("Latency of each upstream instance"),
+		metric.WithUnit("s"),
+	)
+}
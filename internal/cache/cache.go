@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/redis"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	registerOnce sync.Once
+	requests     metric.Int64Counter
+)
+
+func registerMetrics() {
+	requests, _ = otel.Meter("gokit-seed/cache").Int64Counter(
+		"cache.requests",
+		metric.WithDescription("Cache lookups by result: hit, miss or coalesced into a pending miss"),
+	)
+}
+
+// DefaultLoadTimeout bounds the loads of missing values.
+const DefaultLoadTimeout = 10 * time.Second
+
+// Cache looks values up in a local store, then in an optional shared store,
+// and loads them on a miss. Concurrent misses of a key share a single load.
+// A nil *Cache means caching is disabled and always loads.
+type Cache struct {
+	local       Store
+	shared      Store
+	ttl         time.Duration
+	loadTimeout time.Duration
+	group       singleflight.Group
+}
+
+func New(local, shared Store, ttl time.Duration) *Cache {
+	registerOnce.Do(registerMetrics)
+
+	return &Cache{local: local, shared: shared, ttl: ttl, loadTimeout: DefaultLoadTimeout}
+}
+
+// NewCache configures caching from the environment: CACHE_TTL enables it
+// (disabled when unset), CACHE_SIZE bounds the local LRU, at least 1,
+// CACHE_SHARED set to "redis" adds the REDIS_URL server as a shared store and
+// CACHE_LOAD_TIMEOUT bounds loads, DefaultLoadTimeout by default.
+func NewCache(client *redis.Client) (*Cache, error) {
+	if common.GetEnv("CACHE_TTL") == nil {
+		return nil, nil
+	}
+
+	ttl := common.DefaultGetEnvDuration("CACHE_TTL", 0)
+	size := common.DefaultGetEnvInt("CACHE_SIZE", 10_000)
+	loadTimeout := common.DefaultGetEnvDuration("CACHE_LOAD_TIMEOUT", DefaultLoadTimeout)
+
+	if size < 1 {
+		return nil, fmt.Errorf("invalid CACHE_SIZE %d, unset CACHE_TTL to disable caching", size)
+	}
+
+	if loadTimeout <= 0 {
+		return nil, fmt.Errorf("invalid CACHE_LOAD_TIMEOUT %s", loadTimeout)
+	}
+
+	local := NewMemoryStore(size)
+
+	var shared Store
+
+	switch store := os.Getenv("CACHE_SHARED"); store {
+	case "":
+	case "redis":
+		if client == nil {
+			return nil, fmt.Errorf("CACHE_SHARED is redis but REDIS_URL is not set")
+		}
+		shared = NewRedisStore(client, "cache:")
+	default:
+		return nil, fmt.Errorf("invalid CACHE_SHARED %q", store)
+	}
+
+	c := New(local, shared, ttl)
+	c.loadTimeout = loadTimeout
+	return c, nil
+}
+
+// TTL returns zero when caching is disabled.
+func (c *Cache) TTL() time.Duration {
+	if c == nil {
+		return 0
+	}
+
+	return c.ttl
+}
+
+// Get returns the value of key, calling load on a miss. Shared store errors
+// are logged and treated as misses.
+func (c *Cache) Get(ctx context.Context, key string, load func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return load(ctx)
+	}
+
+	if value, _ := c.local.Get(ctx, key); value != nil {
+		c.record(ctx, "hit", "local")
+		return value, nil
+	}
+
+	loaded := false
+
+	result := c.group.DoChan(key, func() (interface{}, error) {
+		loaded = true
+		logger := common.LoggerFromContext(ctx)
+
+		// Others may wait on the load, so neither the cancellation nor the
+		// deadline of the caller that started it, which may come from the
+		// client, apply: it is bounded by loadTimeout only.
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.loadTimeout)
+		defer cancel()
+
+		if c.shared != nil {
+			value, err := c.shared.Get(loadCtx, key)
+
+			if err != nil {
+				logger.Warn("shared cache lookup failed", zap.Error(err))
+			} else if value != nil {
+				c.record(loadCtx, "hit", "shared")
+				c.local.Set(loadCtx, key, value, c.ttl)
+				return value, nil
+			}
+		}
+
+		c.record(loadCtx, "miss", "")
+
+		value, err := load(loadCtx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.local.Set(loadCtx, key, value, c.ttl)
+
+		if c.shared != nil {
+			if err := c.shared.Set(loadCtx, key, value, c.ttl); err != nil {
+				logger.Warn("shared cache update failed", zap.Error(err))
+			}
+		}
+
+		return value, nil
+	})
+
+	// Each caller only waits until its own deadline, the load goes on
+	// without callers that gave up.
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if !loaded {
+			c.record(ctx, "coalesced", "")
+		}
+
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return res.Val.([]byte), nil
+	}
+}
+
+func (c *Cache) record(ctx context.Context, result, tier string) {
+	attrs := []attribute.KeyValue{attribute.String("result", result)}
+
+	if tier != "" {
+		attrs = append(attrs, attribute.String("tier", tier))
+	}
+
+	requests.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+// GetJson is Get for values encoded as JSON.
+func GetJson[T any](ctx context.Context, c *Cache, key string, load func(ctx context.Context) (T, error)) (T, error) {
+	var value T
+
+	data, err := c.Get(ctx, key, func(ctx context.Context) ([]byte, error) {
+		loaded, err := load(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(loaded)
+	})
+
+	if err != nil {
+		return value, err
+	}
+
+	err = json.Unmarshal(data, &value)
+	return value, err
+}
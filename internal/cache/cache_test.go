@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallerDeadlinesOnlyBoundTheirWait(t *testing.T) {
+	c := New(NewMemoryStore(10), nil, time.Minute)
+	release := make(chan struct{})
+	var loadDeadline time.Time
+
+	load := func(ctx context.Context) ([]byte, error) {
+		loadDeadline, _ = ctx.Deadline()
+		<-release
+		return []byte("value"), nil
+	}
+
+	// A caller with a tiny deadline, such as a client supplied timeout,
+	// starts the load and gives up.
+	short, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if _, err := c.Get(short, "key", load); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("short caller got %v", err)
+	}
+
+	// Others waiting on the same load still get the value.
+	done := make(chan error)
+
+	go func() {
+		value, err := c.Get(context.Background(), "key", load)
+
+		if err == nil && string(value) != "value" {
+			err = errors.New("got " + string(value))
+		}
+
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if loadDeadline.Before(start.Add(DefaultLoadTimeout)) {
+		t.Fatalf("load deadline %s, want the load timeout", loadDeadline.Sub(start))
+	}
+}
+
+func TestAbandonedLoadsAreBounded(t *testing.T) {
+	c := New(NewMemoryStore(10), nil, time.Minute)
+	c.loadTimeout = 20 * time.Millisecond
+
+	started := make(chan struct{})
+	ended := make(chan error, 1)
+	canceled, cancelCaller := context.WithCancel(context.Background())
+
+	go c.Get(canceled, "key", func(ctx context.Context) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		ended <- ctx.Err()
+		return nil, ctx.Err()
+	})
+
+	<-started
+	// The caller giving up does not stop the load, the load timeout does.
+	cancelCaller()
+
+	select {
+	case err := <-ended:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("load ended with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("abandoned load kept running")
+	}
+}
+
+func TestConcurrentMissesShareALoad(t *testing.T) {
+	c := New(NewMemoryStore(10), nil, time.Minute)
+	release := make(chan struct{})
+	loads := 0
+	done := make(chan []byte)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			value, _ := c.Get(context.Background(), "key", func(context.Context) ([]byte, error) {
+				loads++
+				<-release
+				return []byte("value"), nil
+			})
+			done <- value
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if value := <-done; string(value) != "value" {
+			t.Fatalf("got %q", value)
+		}
+	}
+
+	if loads != 1 {
+		t.Fatalf("%d loads", loads)
+	}
+}
+
+func TestNewCacheRejectsInvalidSettings(t *testing.T) {
+	t.Setenv("CACHE_TTL", "30s")
+	t.Setenv("CACHE_SIZE", "0")
+
+	if _, err := NewCache(nil); err == nil {
+		t.Fatal("CACHE_SIZE=0 accepted")
+	}
+
+	t.Setenv("CACHE_SIZE", "")
+	t.Setenv("CACHE_LOAD_TIMEOUT", "0s")
+
+	if _, err := NewCache(nil); err == nil {
+		t.Fatal("CACHE_LOAD_TIMEOUT=0s accepted")
+	}
+}
+
+func TestMemoryStoreEvictsTheLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(2)
+	s.Set(ctx, "a", []byte("a"), time.Minute)
+	s.Set(ctx, "b", []byte("b"), time.Minute)
+	s.Get(ctx, "a")
+	s.Set(ctx, "c", []byte("c"), time.Minute)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if value, _ := s.Get(ctx, key); (value != nil) != want {
+			t.Errorf("%s cached: %t, want %t", key, value != nil, want)
+		}
+	}
+
+	// Stores are never empty, a single entry is replaced.
+	s = NewMemoryStore(0)
+	s.Set(ctx, "a", []byte("a"), time.Minute)
+	s.Set(ctx, "b", []byte("b"), time.Minute)
+
+	if value, _ := s.Get(ctx, "b"); value == nil {
+		t.Error("entry not cached")
+	}
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"gokit-seed/internal/redis"
+	"sync"
+	"time"
+)
+
+// Store keeps cached values until their ttl expires. Get reports a miss with
+// a nil value and no error.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryStore keeps entries in process, evicting the least recently used
+// entry once capacity is reached.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// NewMemoryStore holds up to capacity entries, at least one.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: max(capacity, 1),
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+
+	if !ok {
+		return nil, nil
+	}
+
+	e := element.Value.(*entry)
+
+	if time.Now().After(e.expiresAt) {
+		s.order.Remove(element)
+		delete(s.entries, key)
+		return nil, nil
+	}
+
+	s.order.MoveToFront(element)
+	return e.value, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+		delete(s.entries, key)
+	}
+
+	if s.order.Len() >= s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).key)
+	}
+
+	s.entries[key] = s.order.PushFront(&entry{key: key, value: value, expiresAt: time.Now().Add(ttl)})
+	return nil
+}
+
+// RedisStore shares entries between instances through a Redis compatible
+// server.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := s.client.Do(ctx, "GET", s.prefix+key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, _ := reply.([]byte)
+	return value, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.client.Do(ctx, "SET", s.prefix+key, value, "PX", max(ttl.Milliseconds(), 1))
+	return err
+}
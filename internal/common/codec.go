@@ -0,0 +1,154 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings of NewEncoder and NewDecoder. The HTTP "deflate" coding is
+// the zlib format.
+const (
+	EncodingZstd    = "zstd"
+	EncodingBrotli  = "br"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// Encodings are the supported content codings, best first.
+var Encodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip, EncodingDeflate}
+
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+func IsSupportedEncoding(encoding string) bool {
+	return slices.Contains(Encodings, encoding)
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoders keep large buffers, zstd ones especially, so they are reused.
+var encoderPools = map[string]*sync.Pool{
+	EncodingZstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return w
+	}},
+	EncodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(nil, 4)
+	}},
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	EncodingDeflate: {New: func() any {
+		return zlib.NewWriter(nil)
+	}},
+}
+
+// Encoder compresses what is written to it into the underlying writer.
+type Encoder struct {
+	encoder encoder
+	pool    *sync.Pool
+}
+
+func NewEncoder(encoding string, w io.Writer) (*Encoder, error) {
+	pool, ok := encoderPools[encoding]
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+
+	e := pool.Get().(encoder)
+	e.Reset(w)
+	return &Encoder{encoder: e, pool: pool}, nil
+}
+
+func (e *Encoder) Write(p []byte) (int, error) {
+	return e.encoder.Write(p)
+}
+
+// Flush writes the data compressed so far to the underlying writer.
+func (e *Encoder) Flush() error {
+	return e.encoder.Flush()
+}
+
+// Close ends the compressed stream without closing the underlying writer.
+func (e *Encoder) Close() error {
+	if e.encoder == nil {
+		return nil
+	}
+
+	err := e.encoder.Close()
+	e.pool.Put(e.encoder)
+	e.encoder = nil
+	return err
+}
+
+// NewDecoder decompresses r, reading the header of gzip and deflate streams
+// right away. maxSize, when positive, is the decompressed size the caller
+// accepts, which also bounds the window zstd decoders allocate: reading
+// streams needing a larger one fails with an *http.MaxBytesError.
+func NewDecoder(encoding string, r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingZstd:
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+
+		if maxSize > 0 {
+			limit := min(max(uint64(maxSize), zstd.MinWindowSize), zstd.MaxWindowSize)
+			opts = append(opts, zstd.WithDecoderMaxWindow(limit), zstd.WithDecoderMaxMemory(limit))
+		}
+
+		d, err := zstd.NewReader(r, opts...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if maxSize > 0 {
+			return &zstdDecoder{ReadCloser: d.IOReadCloser(), maxSize: maxSize}, nil
+		}
+
+		return d.IOReadCloser(), nil
+	case EncodingBrotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case EncodingGzip:
+		d, err := gzip.NewReader(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	case EncodingDeflate:
+		return zlib.NewReader(r)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// zstdDecoder reports streams exceeding the memory limits of the decoder as
+// too large.
+type zstdDecoder struct {
+	io.ReadCloser
+	maxSize int64
+}
+
+func (d *zstdDecoder) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		err = &http.MaxBytesError{Limit: d.maxSize}
+	}
+
+	return n, err
+}
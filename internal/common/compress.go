@@ -0,0 +1,331 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DefaultCompressionMinSize = 1024
+
+type CompressionConfig struct {
+	// Encodings are offered to clients, the first preferred among those they
+	// accept equally. Empty disables response compression.
+	Encodings []string
+	// Smaller responses are sent as is.
+	MinSize int
+	// ContentTypes are the media types compressed, those ending with a slash
+	// match any subtype.
+	ContentTypes []string
+	// MaxDecompressedSize bounds compressed request bodies once decompressed.
+	MaxDecompressedSize int64
+}
+
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Encodings: Encodings,
+		MinSize:   DefaultCompressionMinSize,
+		ContentTypes: []string{
+			"text/",
+			"application/json",
+			"application/problem+json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+		},
+		MaxDecompressedSize: 10 << 20,
+	}
+}
+
+// CompressionConfigFromEnv overrides DefaultCompressionConfig with
+// COMPRESSION_ENCODINGS, "none" disabling response compression,
+// COMPRESSION_MIN_SIZE, COMPRESSION_TYPES and COMPRESSION_MAX_DECOMPRESSED_SIZE.
+func CompressionConfigFromEnv() CompressionConfig {
+	cfg := DefaultCompressionConfig()
+
+	switch value := os.Getenv("COMPRESSION_ENCODINGS"); value {
+	case "":
+	case "none":
+		cfg.Encodings = nil
+	default:
+		cfg.Encodings = splitEnvList(value)
+
+		for _, encoding := range cfg.Encodings {
+			if !IsSupportedEncoding(encoding) {
+				panic("COMPRESSION_ENCODINGS has an unsupported encoding: " + encoding)
+			}
+		}
+	}
+
+	if types := splitEnvList(os.Getenv("COMPRESSION_TYPES")); len(types) > 0 {
+		cfg.ContentTypes = types
+	}
+
+	cfg.MinSize = DefaultGetEnvInt("COMPRESSION_MIN_SIZE", cfg.MinSize)
+	cfg.MaxDecompressedSize = int64(DefaultGetEnvInt("COMPRESSION_MAX_DECOMPRESSED_SIZE", int(cfg.MaxDecompressedSize)))
+
+	return cfg
+}
+
+var compressionConfig = sync.OnceValue(CompressionConfigFromEnv)
+
+// WithCompression is a HandleChain decompressing request bodies sent with a
+// Content-Encoding, answering 415 to unsupported ones and failing reads past
+// the decompressed size limit with an *http.MaxBytesError. Responses are
+// compressed in the encoding negotiated from Accept-Encoding when they are
+// large enough and of an allowed content type.
+func WithCompression(next http.Handler) http.Handler {
+	cfg := compressionConfig()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding != "" && encoding != "identity" {
+			body, err := NewDecoder(encoding, r.Body, cfg.MaxDecompressedSize)
+
+			if errors.Is(err, ErrUnsupportedEncoding) {
+				w.Header().Set("Accept-Encoding", strings.Join(Encodings, ", "))
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+
+			if err != nil {
+				http.Error(w, "invalid compressed body", http.StatusBadRequest)
+				return
+			}
+
+			defer body.Close()
+
+			r.Body = http.MaxBytesReader(w, body, cfg.MaxDecompressedSize)
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), cfg.Encodings)
+
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: w, cfg: cfg, encoding: encoding, status: http.StatusOK}
+		defer writer.close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// negotiateEncoding picks the encoding of offered with the highest quality in
+// an Accept-Encoding header, if any.
+func negotiateEncoding(accept string, offered []string) string {
+	if accept == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	wildcard := -1.0
+
+	for _, item := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			} else {
+				quality = 0
+			}
+		}
+
+		switch name {
+		case "*":
+			wildcard = quality
+		case "x-gzip":
+			qualities[EncodingGzip] = quality
+		default:
+			qualities[name] = quality
+		}
+	}
+
+	best, bestQuality := "", 0.0
+
+	for _, encoding := range offered {
+		quality, ok := qualities[encoding]
+
+		if !ok {
+			quality = wildcard
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+
+	return best
+}
+
+// compressWriter buffers the start of the body until it reaches MinSize or
+// the handler flushes or returns, then decides whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	cfg         CompressionConfig
+	encoding    string
+	status      int
+	wroteHeader bool
+	started     bool
+	buf         []byte
+	encoder     *Encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.started || w.wroteHeader {
+		return
+	}
+
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.status, w.wroteHeader = status, true
+
+	if !bodyAllowed(status) {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.started {
+		if w.encoder != nil {
+			return w.encoder.Write(p)
+		}
+
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.wroteHeader = true
+	w.buf = append(w.buf, p...)
+
+	if len(w.buf) < w.cfg.MinSize {
+		return len(p), nil
+	}
+
+	if err := w.start(true); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *compressWriter) Flush() {
+	if !w.started {
+		w.start(true)
+	}
+
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if !w.started {
+		if !w.wroteHeader {
+			return
+		}
+
+		w.start(false)
+	}
+
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
+
+// start writes the header, then the buffered body, compressed when eligible
+// is set and the response allows it.
+func (w *compressWriter) start(eligible bool) error {
+	w.started = true
+	header := w.Header()
+
+	if eligible && w.compressible() {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+
+		// The compressed representation is not byte for byte the one the
+		// handler tagged.
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		w.encoder, _ = NewEncoder(w.encoding, w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+
+	var err error
+
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+
+	if !bodyAllowed(w.status) || w.status == http.StatusPartialContent ||
+		header.Get("Content-Encoding") != "" ||
+		strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+
+	if contentType == "" {
+		if len(w.buf) == 0 {
+			return false
+		}
+
+		// net/http would sniff the compressed bytes otherwise.
+		contentType = http.DetectContentType(w.buf)
+		header.Set("Content-Type", contentType)
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	for _, allowed := range w.cfg.ContentTypes {
+		if mediaType == allowed || strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
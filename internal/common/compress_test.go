@@ -0,0 +1,296 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, br", "br"},
+		{"gzip;q=1, br;q=0.5", "gzip"},
+		{"x-gzip", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.1, deflate", "deflate"},
+		{"*;q=0, gzip", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip;q=bogus, deflate", "deflate"},
+		{"identity", ""},
+		{"compress", ""},
+	} {
+		if got := negotiateEncoding(tc.accept, Encodings); got != tc.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func serveCompressed(t *testing.T, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	WithCompression(handler).ServeHTTP(w, r)
+	return w
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	if encoding == "" {
+		return string(body)
+	}
+
+	decoder, err := NewDecoder(encoding, bytes.NewReader(body), 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	decoded, err := io.ReadAll(decoder)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(decoded)
+}
+
+func TestWithCompressionCompressesLargeAllowedResponses(t *testing.T) {
+	large := strings.Repeat(`{"result":"abc"}`, 200)
+
+	for _, encoding := range Encodings {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+
+		w := serveCompressed(t, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", "3200")
+			w.Header().Set("ETag", `"abc"`)
+			// Written in small chunks crossing the threshold.
+			for i := 0; i < len(large); i += 100 {
+				io.WriteString(w, large[i:i+100])
+			}
+		}, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("Content-Encoding %q, want %q", got, encoding)
+		}
+
+		if w.Header().Get("Content-Length") != "" {
+			t.Error("Content-Length of the uncompressed body kept")
+		}
+
+		if got := w.Header().Get("ETag"); got != `W/"abc"` {
+			t.Errorf("ETag %s, want a weak one", got)
+		}
+
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary %q", got)
+		}
+
+		if got := decode(t, encoding, w.Body.Bytes()); got != large {
+			t.Fatalf("%s body does not round trip", encoding)
+		}
+	}
+}
+
+func TestWithCompressionSkips(t *testing.T) {
+	large := strings.Repeat("a", 2*DefaultCompressionMinSize)
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"small body", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"result":"abc"}`)
+		}},
+		{"disallowed type", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}},
+		{"already encoded", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, large)
+		}},
+		{"no-transform", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Cache-Control", "no-transform")
+			io.WriteString(w, large)
+		}},
+		{"not modified", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(http.StatusNotModified)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			w := serveCompressed(t, tc.handler, r)
+
+			if w.Header().Get("Content-Encoding") == "gzip" {
+				t.Fatal("response compressed")
+			}
+
+			if got := w.Header().Get("ETag"); strings.HasPrefix(got, "W/") {
+				t.Errorf("ETag %s weakened without compression", got)
+			}
+		})
+	}
+}
+
+func TestWithCompressionSniffsBeforeCompressing(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	w := serveCompressed(t, func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "<html>"+strings.Repeat("a", 2*DefaultCompressionMinSize))
+	}, r)
+
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Fatalf("Content-Type %q, want the sniffed one", got)
+	}
+
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("sniffed text response not compressed")
+	}
+}
+
+func TestWithCompressionFlushStartsTheStream(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	var flushed int
+
+	w := serveCompressed(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		flushed = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.Len()
+		io.WriteString(w, "data: 2\n\n")
+	}, r)
+
+	if flushed == 0 {
+		t.Fatal("nothing written on flush")
+	}
+
+	if got := decode(t, "gzip", w.Body.Bytes()); got != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("body %q", got)
+	}
+}
+
+func TestWithCompressionDecompressesRequests(t *testing.T) {
+	var compressed bytes.Buffer
+	encoder, _ := NewEncoder(EncodingGzip, &compressed)
+	io.WriteString(encoder, `{"value":"abc"}`)
+	encoder.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed.Bytes()))
+	r.Header.Set("Content-Encoding", "gzip")
+
+	var body string
+
+	serveCompressed(t, func(_ http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Error("Content-Encoding left on the decompressed request")
+		}
+	}, r)
+
+	if body != `{"value":"abc"}` {
+		t.Fatalf("decompressed body %q", body)
+	}
+}
+
+func TestWithCompressionRejectsRequests(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("abc"))
+	r.Header.Set("Content-Encoding", "lz4")
+
+	w := serveCompressed(t, func(http.ResponseWriter, *http.Request) {
+		t.Fatal("handler called")
+	}, r)
+
+	if w.Code != http.StatusUnsupportedMediaType || w.Header().Get("Accept-Encoding") == "" {
+		t.Fatalf("unsupported encoding got %d with Accept-Encoding %q", w.Code, w.Header().Get("Accept-Encoding"))
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+	r.Header.Set("Content-Encoding", "gzip")
+
+	if w := serveCompressed(t, func(http.ResponseWriter, *http.Request) {}, r); w.Code != http.StatusBadRequest {
+		t.Fatalf("corrupt body got %d", w.Code)
+	}
+}
+
+func TestWithCompressionCapsDecompressedSize(t *testing.T) {
+	var compressed bytes.Buffer
+	encoder, _ := NewEncoder(EncodingZstd, &compressed)
+	encoder.Write(make([]byte, DefaultCompressionConfig().MaxDecompressedSize+1))
+	encoder.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed.Bytes()))
+	r.Header.Set("Content-Encoding", "zstd")
+
+	var err error
+
+	serveCompressed(t, func(_ http.ResponseWriter, r *http.Request) {
+		_, err = io.ReadAll(r.Body)
+	}, r)
+
+	var tooLarge *http.MaxBytesError
+
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("reading past the cap returned %v", err)
+	}
+}
+
+func TestNewDecoderBoundsTheZstdWindow(t *testing.T) {
+	data := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	var compressed bytes.Buffer
+	encoder, _ := zstd.NewWriter(&compressed, zstd.WithWindowSize(1<<20))
+	encoder.Write(data)
+	encoder.Close()
+
+	read := func(maxSize int64) error {
+		decoder, err := NewDecoder(EncodingZstd, bytes.NewReader(compressed.Bytes()), maxSize)
+
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+
+		_, err = io.ReadAll(decoder)
+		return err
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	if err := read(64 << 10); !errors.As(err, &tooLarge) || tooLarge.Limit != 64<<10 {
+		t.Fatalf("window past the cap got %v", err)
+	}
+
+	for _, maxSize := range []int64{0, 1 << 20} {
+		if err := read(maxSize); err != nil {
+			t.Fatalf("cap of %d: %v", maxSize, err)
+		}
+	}
+}
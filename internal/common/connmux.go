@@ -0,0 +1,211 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// ConnMux splits connections accepted on a single listener between a gRPC
+// listener and an HTTP listener, based on the first bytes sent by the client.
+// HTTP/2 connections carrying an "application/grpc" content type go to the
+// gRPC listener, everything else (HTTP/1.x, h2c) goes to the HTTP listener.
+type ConnMux struct {
+	root         net.Listener
+	grpc         *muxListener
+	http         *muxListener
+	sniffTimeout time.Duration
+	stallTimeout time.Duration
+	closeOnce    sync.Once
+}
+
+func NewConnMux(root net.Listener) *ConnMux {
+	return &ConnMux{
+		root:         root,
+		grpc:         newMuxListener(root.Addr()),
+		http:         newMuxListener(root.Addr()),
+		sniffTimeout: 5 * time.Second,
+		stallTimeout: 200 * time.Millisecond,
+	}
+}
+
+func (m *ConnMux) GrpcListener() net.Listener {
+	return m.grpc
+}
+
+func (m *ConnMux) HttpListener() net.Listener {
+	return m.http
+}
+
+// Serve accepts connections on the root listener until it is closed.
+func (m *ConnMux) Serve() error {
+	for {
+		conn, err := m.root.Accept()
+
+		if err != nil {
+			m.closeChildren()
+
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		go m.dispatch(conn)
+	}
+}
+
+// Close stops accepting new connections. Connections already handed to the
+// child listeners are left to their servers to drain.
+func (m *ConnMux) Close() error {
+	err := m.root.Close()
+	m.closeChildren()
+	return err
+}
+
+func (m *ConnMux) closeChildren() {
+	m.closeOnce.Do(func() {
+		m.grpc.Close()
+		m.http.Close()
+	})
+}
+
+func (m *ConnMux) dispatch(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(m.sniffTimeout))
+	isGrpc, sniffed, err := sniffGrpc(conn, m.stallTimeout)
+	conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	c := &sniffedConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(sniffed), conn)}
+
+	if isGrpc {
+		m.grpc.deliver(c)
+	} else {
+		m.http.deliver(c)
+	}
+}
+
+// sniffGrpc reads just enough of the connection to tell whether it carries
+// gRPC, returning the bytes consumed so they can be replayed to the server.
+// Clients that stall for stall after their settings are gRPC ones waiting for
+// the server settings, as grpc-go and grpc-java do, since h2c clients send
+// their first headers right away.
+func sniffGrpc(conn net.Conn, stall time.Duration) (bool, []byte, error) {
+	var buf bytes.Buffer
+	reader := io.TeeReader(conn, &buf)
+	preface := []byte(http2.ClientPreface)
+	chunk := make([]byte, len(preface))
+
+	for buf.Len() < len(preface) {
+		n, err := reader.Read(chunk[:len(preface)-buf.Len()])
+
+		if !bytes.HasPrefix(preface, buf.Bytes()) {
+			return false, buf.Bytes(), nil
+		}
+
+		if err != nil {
+			if n > 0 || buf.Len() > 0 {
+				return false, buf.Bytes(), nil
+			}
+			return false, nil, err
+		}
+	}
+
+	// Nothing is written while sniffing: the server the connection goes to
+	// sends its own settings, and acknowledgements of extra ones make HTTP/2
+	// servers fail the connection.
+	framer := http2.NewFramer(io.Discard, reader)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	settled := false
+
+	for {
+		frame, err := framer.ReadFrame()
+
+		if err != nil {
+			var netErr net.Error
+			stalled := settled && errors.As(err, &netErr) && netErr.Timeout()
+			return stalled, buf.Bytes(), nil
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() && !settled {
+				settled = true
+				conn.SetReadDeadline(time.Now().Add(stall))
+			}
+		case *http2.MetaHeadersFrame:
+			for _, field := range f.RegularFields() {
+				if field.Name == "content-type" {
+					return strings.HasPrefix(field.Value, "application/grpc"), buf.Bytes(), nil
+				}
+			}
+			return false, buf.Bytes(), nil
+		case *http2.GoAwayFrame:
+			return false, buf.Bytes(), nil
+		}
+	}
+}
+
+type sniffedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+type muxListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *muxListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
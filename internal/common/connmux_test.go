@@ -0,0 +1,177 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func startConnMux(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := NewConnMux(ln)
+
+	httpServer := &http.Server{
+		Handler: h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		}), &http2.Server{}),
+	}
+
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+
+	go httpServer.Serve(mux.HttpListener())
+	go grpcServer.Serve(mux.GrpcListener())
+	go mux.Serve()
+
+	t.Cleanup(func() {
+		mux.Close()
+		grpcServer.Stop()
+		httpServer.Close()
+	})
+
+	return ln.Addr().String()
+}
+
+func TestConnMuxServesH2cAndGrpcOnOneListener(t *testing.T) {
+	addr := startConnMux(t)
+
+	h2cClient := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	// Several requests share the connection, which the server closes on a
+	// protocol error.
+	for i := 0; i < 3; i++ {
+		res, err := h2cClient.Get("http://" + addr + "/")
+
+		if err != nil {
+			t.Fatalf("h2c request %d: %v", i, err)
+		}
+
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if string(body) != "HTTP/2.0" {
+			t.Fatalf("h2c request %d served as %q", i, body)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	res, err := (&http.Client{Timeout: 5 * time.Second}).Get("http://" + addr + "/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if string(body) != "HTTP/1.1" {
+		t.Fatalf("HTTP/1.1 request served as %q", body)
+	}
+
+	conn, err := grpc.NewClient("passthrough:///"+addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithNoProxy())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	check, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if check.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("gRPC health status %s", check.Status)
+	}
+}
+
+func TestSniffGrpcWritesNothing(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stall  bool
+		isGrpc bool
+	}{
+		{name: "closed after settings", stall: false, isGrpc: false},
+		{name: "stalled after settings", stall: true, isGrpc: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			wroteSettings := make(chan struct{})
+			go func() {
+				io.WriteString(client, http2.ClientPreface)
+				http2.NewFramer(client, nil).WriteSettings()
+				close(wroteSettings)
+
+				if !tc.stall {
+					client.Close()
+				}
+			}()
+
+			written := make(chan struct{})
+			go func() {
+				<-wroteSettings
+				// Any write from the sniffer would be read here.
+				buf := make([]byte, 1)
+				if n, _ := client.Read(buf); n > 0 {
+					close(written)
+				}
+			}()
+
+			server.SetReadDeadline(time.Now().Add(time.Second))
+			isGrpc, sniffed, err := sniffGrpc(server, 50*time.Millisecond)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if isGrpc != tc.isGrpc {
+				t.Fatalf("sniffed gRPC %v, want %v", isGrpc, tc.isGrpc)
+			}
+
+			if len(sniffed) < len(http2.ClientPreface) {
+				t.Fatalf("sniffed %d bytes, want the preface replayed", len(sniffed))
+			}
+
+			select {
+			case <-written:
+				t.Fatal("sniffer wrote to the connection")
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CorsOptions struct {
+	// AllowedOrigins lists exact origins, "*", origins with wildcards such as
+	// "https://*.example.com", or regular expressions starting with "^".
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders defaults to the headers requested by the preflight.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+type Cors struct {
+	opts      CorsOptions
+	anyOrigin bool
+	origins   []string
+	patterns  []*regexp.Regexp
+	methods   string
+	headers   string
+	exposed   string
+	maxAge    string
+}
+
+// NewCors fails on invalid origin patterns, and on "*" with credentials,
+// which browsers reject and which would otherwise let any site make
+// credentialed requests.
+func NewCors(opts CorsOptions) (*Cors, error) {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{"GET", "HEAD", "POST"}
+	}
+
+	for i, method := range opts.AllowedMethods {
+		opts.AllowedMethods[i] = strings.ToUpper(method)
+	}
+
+	c := &Cors{
+		opts:    opts,
+		methods: strings.Join(opts.AllowedMethods, ", "),
+		headers: strings.Join(opts.AllowedHeaders, ", "),
+		exposed: strings.Join(opts.ExposedHeaders, ", "),
+	}
+
+	if opts.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+
+	for _, origin := range opts.AllowedOrigins {
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.HasPrefix(origin, "^"):
+			pattern, err := regexp.Compile(origin)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid CORS origin pattern %q: %w", origin, err)
+			}
+
+			c.patterns = append(c.patterns, pattern)
+		case strings.Contains(origin, "*"):
+			pattern := strings.ReplaceAll(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[^/]*`)
+			c.patterns = append(c.patterns, regexp.MustCompile("^"+pattern+"$"))
+		default:
+			c.origins = append(c.origins, strings.ToLower(origin))
+		}
+	}
+
+	if c.anyOrigin && opts.AllowCredentials {
+		return nil, errors.New(`CORS allowed origins can not be "*" with credentials, list the origins instead`)
+	}
+
+	return c, nil
+}
+
+// NewCorsFromEnv builds the CORS policy of the route group at path from the
+// CORS_* variables. Each one can be overridden for the group by inserting its
+// path, such as CORS_STRINGS_ALLOWED_ORIGINS for "/strings", where "none"
+// disables CORS. It returns nil, disabling CORS, when no origins are allowed.
+func NewCorsFromEnv(path string) (*Cors, error) {
+	group := "CORS_" + strings.ToUpper(strings.NewReplacer("/", "_", "-", "_").Replace(strings.Trim(path, "/"))) + "_"
+
+	key := func(name string) string {
+		if os.Getenv(group+name) != "" {
+			return group + name
+		}
+
+		return "CORS_" + name
+	}
+
+	origins := GetEnv(key("ALLOWED_ORIGINS"))
+
+	if origins == nil || *origins == "none" {
+		return nil, nil
+	}
+
+	return NewCors(CorsOptions{
+		AllowedOrigins:   splitEnvList(*origins),
+		AllowedMethods:   splitEnvList(os.Getenv(key("ALLOWED_METHODS"))),
+		AllowedHeaders:   splitEnvList(os.Getenv(key("ALLOWED_HEADERS"))),
+		ExposedHeaders:   splitEnvList(os.Getenv(key("EXPOSED_HEADERS"))),
+		AllowCredentials: DefaultGetEnvBool(key("ALLOW_CREDENTIALS"), false),
+		MaxAge:           DefaultGetEnvDuration(key("MAX_AGE"), 10*time.Minute),
+	})
+}
+
+func splitEnvList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func (c *Cors) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	if slices.Contains(c.origins, origin) {
+		return true
+	}
+
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handler is a HandleChain adding CORS headers to responses and answering
+// preflight requests itself, without reaching the wrapped handler.
+func (c *Cors) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		header := w.Header()
+
+		header.Add("Vary", "Origin")
+
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin == "" || !c.allowOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c.anyOrigin {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if c.opts.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if c.exposed != "" {
+				header.Set("Access-Control-Expose-Headers", c.exposed)
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !slices.Contains(c.opts.AllowedMethods, strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", c.methods)
+
+		if c.headers != "" {
+			header.Set("Access-Control-Allow-Headers", c.headers)
+		} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+
+		if c.maxAge != "" {
+			header.Set("Access-Control-Max-Age", c.maxAge)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveCors(t *testing.T, cors *Cors, origin string) http.Header {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/strings/greetings", nil)
+	r.Header.Set("Origin", origin)
+	w := httptest.NewRecorder()
+
+	cors.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+	return w.Header()
+}
+
+func TestNewCorsRejectsInvalidOptions(t *testing.T) {
+	for _, opts := range []CorsOptions{
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"^https://(example.com$"}},
+	} {
+		if _, err := NewCors(opts); err == nil {
+			t.Errorf("%+v accepted", opts)
+		}
+	}
+}
+
+func TestCorsAllowsOrigins(t *testing.T) {
+	cors, err := NewCors(CorsOptions{
+		AllowedOrigins:   []string{"http://localhost:5173", "https://*.example.com", `^https://app\d+\.test$`},
+		AllowCredentials: true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for origin, want := range map[string]string{
+		"http://localhost:5173":     "http://localhost:5173",
+		"https://api.example.com":   "https://api.example.com",
+		"https://app1.test":         "https://app1.test",
+		"https://example.com":       "",
+		"https://evil.com":          "",
+		"https://a.b.example.com/x": "",
+	} {
+		if got := serveCors(t, cors, origin).Get("Access-Control-Allow-Origin"); got != want {
+			t.Errorf("%s allowed as %q, want %q", origin, got, want)
+		}
+	}
+
+	cors, err = NewCors(CorsOptions{AllowedOrigins: []string{"*"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header := serveCors(t, cors, "https://evil.com"); header.Get("Access-Control-Allow-Origin") != "*" || header.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("any origin answered with %v", header)
+	}
+}
+
+func TestNewCorsFromEnvOverridesPerGroup(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://example.com")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_ADMIN_ALLOWED_ORIGINS", "https://admin.example.com")
+	t.Setenv("CORS_STRINGS_ALLOWED_ORIGINS", "none")
+
+	cors, err := NewCorsFromEnv("/admin")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header := serveCors(t, cors, "https://admin.example.com"); header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("group override answered with %v", header)
+	}
+
+	if cors, _ := NewCorsFromEnv("/strings"); cors != nil {
+		t.Error("CORS not disabled for the group")
+	}
+
+	if cors, _ := NewCorsFromEnv("/users"); cors == nil || serveCors(t, cors, "https://example.com").Get("Access-Control-Allow-Origin") == "" {
+		t.Error("global policy not applied")
+	}
+
+	t.Setenv("CORS_USERS_ALLOWED_ORIGINS", "*")
+
+	if _, err := NewCorsFromEnv("/users"); err == nil {
+		t.Error(`"*" with global credentials accepted`)
+	}
+}
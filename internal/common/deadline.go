@@ -0,0 +1,149 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HeaderRequestTimeout carries the time left to serve a request, formatted
+// like the grpc-timeout header: an integer of at most 8 digits followed by a
+// unit, H, M, S, m (milliseconds), u (microseconds) or n (nanoseconds).
+const HeaderRequestTimeout = "X-Request-Timeout"
+
+var timeoutUnits = []struct {
+	unit     byte
+	duration time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// FormatTimeout uses the finest unit fitting in 8 digits, rounding down so
+// that upstreams never get more time than the caller has.
+func FormatTimeout(timeout time.Duration) string {
+	for _, u := range timeoutUnits {
+		value := timeout / u.duration
+
+		if value < 100_000_000 {
+			return strconv.FormatInt(int64(value), 10) + string(u.unit)
+		}
+	}
+
+	return "99999999H"
+}
+
+func ParseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+
+	digits := value[:len(value)-1]
+	n, err := strconv.ParseInt(digits, 10, 64)
+
+	if err != nil || strings.Trim(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+
+	for _, u := range timeoutUnits {
+		if u.unit == value[len(value)-1] {
+			if n > math.MaxInt64/int64(u.duration) {
+				return math.MaxInt64, nil
+			}
+
+			return time.Duration(n) * u.duration, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid timeout unit %q", value)
+}
+
+// SetTimeoutHeader sets HeaderRequestTimeout from the deadline of ctx, if any.
+func SetTimeoutHeader(ctx context.Context, header http.Header) {
+	if deadline, ok := ctx.Deadline(); ok {
+		header.Set(HeaderRequestTimeout, FormatTimeout(max(time.Until(deadline), 0)))
+	}
+}
+
+// TimeoutError is the cause of the deadline set by WithTimeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %s", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+var routeTimeouts = sync.OnceValue(func() map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	value := os.Getenv("ROUTE_TIMEOUTS")
+
+	if value == "" {
+		return timeouts
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		route, duration, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		timeout, err := time.ParseDuration(duration)
+
+		if !ok || err != nil || timeout < 0 {
+			panic("ROUTE_TIMEOUTS has an invalid entry: " + entry)
+		}
+
+		timeouts[route] = timeout
+	}
+
+	return timeouts
+})
+
+// WithTimeout returns a HandleChain bounding requests to route by timeout,
+// unless ROUTE_TIMEOUTS overrides it as "route=duration,...", or by the
+// inbound HeaderRequestTimeout when shorter. A zero timeout only applies the
+// inbound one. The context deadline is caused by a *TimeoutError.
+func WithTimeout(route string, timeout time.Duration) HandleChain {
+	if override, ok := routeTimeouts()[route]; ok {
+		timeout = override
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, bounded := timeout, timeout > 0
+
+			if value := r.Header.Get(HeaderRequestTimeout); value != "" {
+				inbound, err := ParseTimeout(value)
+
+				if err != nil {
+					LoggerFromContext(r.Context()).Debug("invalid request timeout ignored", zap.Error(err))
+				} else if !bounded || inbound < limit {
+					limit, bounded = inbound, true
+				}
+			}
+
+			if !bounded {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeoutCause(r.Context(), limit, &TimeoutError{Timeout: limit})
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
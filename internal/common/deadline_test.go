@@ -0,0 +1,139 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFormatTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{0, "0n"},
+		{1500 * time.Nanosecond, "1500n"},
+		{99_999_999 * time.Nanosecond, "99999999n"},
+		{100 * time.Millisecond, "100000u"},
+		// Rounded down to the unit.
+		{100*time.Second + time.Nanosecond, "100000m"},
+		{30 * time.Hour, "108000S"},
+		{time.Duration(1<<63 - 1), "2562047H"},
+	} {
+		if got := FormatTimeout(tc.timeout); got != tc.want {
+			t.Errorf("FormatTimeout(%s) = %q, want %q", tc.timeout, got, tc.want)
+		}
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"0n", 0},
+		{"100m", 100 * time.Millisecond},
+		{"3S", 3 * time.Second},
+		{"2M", 2 * time.Minute},
+		{"1H", time.Hour},
+		{"250u", 250 * time.Microsecond},
+		{"99999999H", time.Duration(1<<63 - 1)},
+	} {
+		if got, err := ParseTimeout(tc.value); err != nil || got != tc.want {
+			t.Errorf("ParseTimeout(%q) = %s, %v, want %s", tc.value, got, err, tc.want)
+		}
+	}
+
+	for _, value := range []string{"", "S", "5", "5s", "-5S", "+5S", "1.5S", "100000000m"} {
+		if _, err := ParseTimeout(value); err == nil {
+			t.Errorf("ParseTimeout(%q) accepted", value)
+		}
+	}
+
+	for _, timeout := range []time.Duration{time.Nanosecond, 1234567 * time.Microsecond, 42 * time.Hour} {
+		if got, _ := ParseTimeout(FormatTimeout(timeout)); got > timeout || got < timeout-timeout/1000 {
+			t.Errorf("%s round trips to %s", timeout, got)
+		}
+	}
+}
+
+func TestSetTimeoutHeader(t *testing.T) {
+	header := http.Header{}
+	SetTimeoutHeader(context.Background(), header)
+
+	if header.Get(HeaderRequestTimeout) != "" {
+		t.Fatal("header set without a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	SetTimeoutHeader(ctx, header)
+	timeout, err := ParseTimeout(header.Get(HeaderRequestTimeout))
+
+	if err != nil || timeout <= 0 || timeout > time.Second {
+		t.Fatalf("header %q", header.Get(HeaderRequestTimeout))
+	}
+
+	// Expired deadlines are sent as no time left.
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	SetTimeoutHeader(expired, header)
+
+	if got := header.Get(HeaderRequestTimeout); got != "0n" {
+		t.Fatalf("expired deadline sent as %q", got)
+	}
+}
+
+func TestWithTimeoutTakesTheShortest(t *testing.T) {
+	for _, tc := range []struct {
+		timeout time.Duration
+		inbound string
+		want    time.Duration
+	}{
+		{time.Second, "", time.Second},
+		{time.Second, "100m", 100 * time.Millisecond},
+		{time.Second, "5S", time.Second},
+		{time.Second, "bogus", time.Second},
+		{0, "2S", 2 * time.Second},
+		{0, "", 0},
+	} {
+		var got time.Duration
+
+		handler := WithTimeout("/test", tc.timeout)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			if deadline, ok := r.Context().Deadline(); ok {
+				got = time.Until(deadline)
+			}
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+		if tc.inbound != "" {
+			r.Header.Set(HeaderRequestTimeout, tc.inbound)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if got > tc.want || got < tc.want-100*time.Millisecond {
+			t.Errorf("timeout %s with %q bounded to %s, want %s", tc.timeout, tc.inbound, got, tc.want)
+		}
+	}
+}
+
+func TestWithTimeoutCause(t *testing.T) {
+	var timeoutErr *TimeoutError
+	var got error
+
+	WithTimeout("/test", time.Millisecond)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		got = context.Cause(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if !errors.As(got, &timeoutErr) || timeoutErr.Timeout != time.Millisecond || !errors.Is(got, context.DeadlineExceeded) {
+		t.Fatalf("deadline caused by %v", got)
+	}
+}
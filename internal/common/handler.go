@@ -12,10 +12,24 @@ import (
 
 type key int
 
-var loggerKey key
+const (
+	loggerKey key = iota
+	requestIdKey
+)
 
+// LoggerFromContext falls back to the global logger when the context does not
+// carry one, e.g. outside of the HTTP handler chain.
 func LoggerFromContext(ctx context.Context) *zap.Logger {
-	return ctx.Value(loggerKey).(*zap.Logger)
+	if logger, ok := ctx.Value(loggerKey).(*zap.Logger); ok {
+		return logger
+	}
+
+	return zap.L()
+}
+
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
 }
 
 func ContextWithLogger(ctx context.Context, logger *zap.Logger) context.Context {
@@ -85,5 +99,6 @@ func (h *withRequestId) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("X-Request-Id", requestId)
 	ctx := context.WithValue(r.Context(), loggerKey, logger)
+	ctx = context.WithValue(ctx, requestIdKey, requestId)
 	h.next.ServeHTTP(w, r.WithContext(ctx))
 }
@@ -1,6 +1,11 @@
 package common
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 func MustGetEnv(key string) string {
 	value := os.Getenv(key)
@@ -31,3 +36,58 @@ func DefaultGetEnvBool(key string, defaultValue bool) bool {
 
 	return value == "true"
 }
+
+func DefaultGetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		panic(key + " is not a valid duration: " + err.Error())
+	}
+
+	return duration
+}
+
+func DefaultGetEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+
+	if err != nil {
+		panic(key + " is not a valid integer: " + err.Error())
+	}
+
+	return number
+}
+
+// DefaultGetEnvFloats parses a comma separated list of numbers.
+func DefaultGetEnvFloats(key string, defaultValue []float64) []float64 {
+	value := os.Getenv(key)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	var numbers []float64
+
+	for _, item := range strings.Split(value, ",") {
+		number, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+
+		if err != nil {
+			panic(key + " is not a valid list of numbers: " + err.Error())
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	return numbers
+}
@@ -4,20 +4,47 @@ package common
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 type Router struct {
 	*httprouter.Router
+	routes []RouteInfo
+}
+
+// RouteInfo describes a route registered on a Router.
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
 }
 
 func NewRouter(r *httprouter.Router) *Router {
 	if r != nil {
-		return &Router{r}
+		return &Router{Router: r}
 	}
 
-	return &Router{httprouter.New()}
+	return &Router{Router: httprouter.New()}
+}
+
+func (r *Router) Handle(method, path string, handle httprouter.Handle) {
+	r.routes = append(r.routes, RouteInfo{Method: method, Path: path})
+	r.Router.Handle(method, path, handle)
+}
+
+func (r *Router) Handler(method, path string, handler http.Handler) {
+	r.routes = append(r.routes, RouteInfo{Method: method, Path: path})
+	r.Router.Handler(method, path, handler)
+}
+
+func (r *Router) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	r.Handler(method, path, handler)
+}
+
+// Routes returns the routes registered so far, in registration order.
+func (r *Router) Routes() []RouteInfo {
+	return append([]RouteInfo(nil), r.routes...)
 }
 
 // NewGroup adds a zero overhead group of routes that share a common root path.
@@ -91,10 +118,23 @@ func (r *RouteGroup) DELETE(path string, handle httprouter.Handle) {
 	r.Handle("DELETE", path, handle)
 }
 
+// Routes returns every route registered on the router backing this group.
+func (r *RouteGroup) Routes() []RouteInfo {
+	return r.r.Routes()
+}
+
 func (r *RouteGroup) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.r.ServeHTTP(w, req)
 }
 
+// PolicyName names the authorization policy of the route at path after its
+// full path, "/strings/reversions" being guarded by "strings.reversions".
+func (r *RouteGroup) PolicyName(path string) string {
+	name := strings.Trim(r.SubPath(path), "/")
+	name = strings.NewReplacer("/", ".", ":", "", "*", "").Replace(name)
+	return name
+}
+
 func (r *RouteGroup) SubPath(path string) string {
 	if path[0] != '/' {
 		panic("path must start with a '/'")
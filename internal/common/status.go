@@ -0,0 +1,17 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// StatusError is returned by client decoders for non successful upstream
+// responses.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream responded %d %s", e.Code, http.StatusText(e.Code))
+}
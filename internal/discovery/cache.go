@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/go-kit/kit/sd"
+)
+
+// Cache stores the last known instances and broadcasts changes to registered
+// channels. It implements sd.Instancer and is the building block of every
+// source in this package.
+type Cache struct {
+	mu    sync.Mutex
+	state sd.Event
+	subs  map[chan<- sd.Event]struct{}
+}
+
+func NewCache() *Cache {
+	return &Cache{subs: map[chan<- sd.Event]struct{}{}}
+}
+
+// Update broadcasts event unless it matches the current state. Errors keep
+// the last known instances so that endpointers can keep using them.
+func (c *Cache) Update(event sd.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sort.Strings(event.Instances)
+
+	if event.Err != nil {
+		event.Instances = c.state.Instances
+	}
+
+	if event.Err == c.state.Err && slices.Equal(event.Instances, c.state.Instances) {
+		return
+	}
+
+	c.state = event
+
+	for ch := range c.subs {
+		ch <- copyEvent(event)
+	}
+}
+
+// State returns the current instances or error.
+func (c *Cache) State() sd.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return copyEvent(c.state)
+}
+
+// Register sends the current state to ch, then every change.
+func (c *Cache) Register(ch chan<- sd.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.subs[ch] = struct{}{}
+	ch <- copyEvent(c.state)
+}
+
+func (c *Cache) Deregister(ch chan<- sd.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.subs, ch)
+}
+
+func (c *Cache) Stop() {}
+
+// Subscribers need their own copy since sd.Endpointer sorts in place.
+func copyEvent(event sd.Event) sd.Event {
+	event.Instances = slices.Clone(event.Instances)
+	return event
+}
@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// NewConsulInstancer watches the passing instances of service through the
+// Consul health HTTP API at addr, such as "http://localhost:8500", using
+// blocking queries. Each instance becomes scheme://address:port followed by
+// path. interval is the delay between queries.
+func NewConsulInstancer(addr, service, tag, scheme, path string, interval time.Duration, client *http.Client, logger *zap.Logger) sd.Instancer {
+	var index string
+
+	// Consul answers blocking queries after wait, keep it below the client
+	// timeout.
+	wait := 5 * time.Minute
+
+	if client.Timeout > 0 {
+		wait = min(wait, client.Timeout/2)
+	}
+
+	return newPoller("consul://"+service, interval, func(ctx context.Context) ([]string, error) {
+		query := url.Values{"passing": {"true"}, "wait": {wait.String()}}
+
+		if tag != "" {
+			query.Set("tag", tag)
+		}
+
+		if index != "" {
+			query.Set("index", index)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", addr+"/v1/health/service/"+url.PathEscape(service)+"?"+query.Encode(), nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("consul: unexpected status %s", res.Status)
+		}
+
+		var entries []consulEntry
+
+		if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("consul: %w", err)
+		}
+
+		// Consul may reset the index, start over rather than block forever.
+		if next, err := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64); err == nil && next > 0 {
+			if current, _ := strconv.ParseUint(index, 10, 64); next < current {
+				next = 0
+			}
+			index = strconv.FormatUint(next, 10)
+		}
+
+		instances := make([]string, 0, len(entries))
+
+		for _, entry := range entries {
+			host := entry.Service.Address
+
+			if host == "" {
+				host = entry.Node.Address
+			}
+
+			instances = append(instances, instanceUrl(scheme, host, strconv.Itoa(entry.Service.Port), path))
+		}
+
+		return instances, nil
+	}, logger)
+}
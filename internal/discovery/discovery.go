@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+func newEvent(instances []string, err error) sd.Event {
+	if err != nil {
+		return sd.Event{Err: err}
+	}
+
+	if instances == nil {
+		instances = []string{}
+	}
+
+	return sd.Event{Instances: instances}
+}
+
+// New returns the instancer described by spec:
+//
+//	dns+srv://_http._tcp.test.internal    SRV records
+//	dns://test.internal:8080              A and AAAA records
+//	file:///etc/upstreams.yaml            watched JSON or YAML list of URLs
+//	consul://localhost:8500/test?tag=v1   Consul passing health checks
+//	etcd://localhost:2379/services/test/  etcd keys under a prefix
+//
+// Every spec accepts an "interval" between refreshes, and sources returning
+// addresses a "scheme" (http by default) and a "path" appended to each
+// instance URL.
+func New(spec string, client *http.Client, logger *zap.Logger) (sd.Instancer, error) {
+	u, err := url.Parse(spec)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery spec %q: %w", spec, err)
+	}
+
+	query := u.Query()
+	scheme := query.Get("scheme")
+	urlPath := query.Get("path")
+	interval := 10 * time.Second
+
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	if value := query.Get("interval"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid discovery interval %q", value)
+		}
+	}
+
+	switch u.Scheme {
+	case "dns+srv":
+		return NewDnsSrvInstancer(u.Host, scheme, urlPath, interval, logger), nil
+	case "dns":
+		host, port, err := net.SplitHostPort(u.Host)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid discovery spec %q: %w", spec, err)
+		}
+
+		return NewDnsInstancer(host, port, scheme, urlPath, interval, logger), nil
+	case "file":
+		// Accept file:///abs, file://./rel and file:rel.
+		path := u.Opaque
+
+		if path == "" {
+			path = u.Host + u.Path
+		}
+
+		return NewFileInstancer(path, interval, logger), nil
+	case "consul":
+		service := strings.Trim(u.Path, "/")
+
+		if service == "" {
+			return nil, fmt.Errorf("invalid discovery spec %q: missing service name", spec)
+		}
+
+		// Blocking queries wait for changes, only pause between them.
+		if !query.Has("interval") {
+			interval = time.Second
+		}
+
+		return NewConsulInstancer("http://"+u.Host, service, query.Get("tag"), scheme, urlPath, interval, client, logger), nil
+	case "etcd":
+		return NewEtcdInstancer("http://"+u.Host, u.Path, interval, client, logger), nil
+	}
+
+	return nil, fmt.Errorf("unsupported discovery spec %q", spec)
+}
@@ -0,0 +1,222 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+// waitEvent returns the first event of instancer matching ok.
+func waitEvent(t *testing.T, instancer sd.Instancer, ok func(sd.Event) bool) sd.Event {
+	t.Helper()
+
+	events := make(chan sd.Event, 16)
+	instancer.Register(events)
+	defer instancer.Deregister(events)
+
+	timeout := time.After(2 * time.Second)
+
+	for {
+		select {
+		case event := <-events:
+			if ok(event) {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("no matching event")
+		}
+	}
+}
+
+func hasInstances(want ...string) func(sd.Event) bool {
+	return func(event sd.Event) bool {
+		return event.Err == nil && slices.Equal(event.Instances, want)
+	}
+}
+
+// fakeConsul answers health queries with entries, blocking queries at the
+// current index until the entries change.
+type fakeConsul struct {
+	mu      sync.Mutex
+	index   int
+	entries string
+	changed chan struct{}
+	queries []*http.Request
+}
+
+func newFakeConsul(entries string) *fakeConsul {
+	return &fakeConsul{index: 1, entries: entries, changed: make(chan struct{})}
+}
+
+func (c *fakeConsul) set(entries string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.index++
+	c.entries = entries
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.queries = append(c.queries, r)
+	changed := c.changed
+
+	if r.URL.Query().Get("index") == strconv.Itoa(c.index) {
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+
+		c.mu.Lock()
+	}
+
+	w.Header().Set("X-Consul-Index", strconv.Itoa(c.index))
+	w.Write([]byte(c.entries))
+	c.mu.Unlock()
+}
+
+func TestConsulInstancerFollowsBlockingQueries(t *testing.T) {
+	consul := newFakeConsul(`[
+		{"Node": {"Address": "10.0.0.1"}, "Service": {"Address": "", "Port": 8080}},
+		{"Node": {"Address": "10.0.0.2"}, "Service": {"Address": "test.internal", "Port": 8081}}
+	]`)
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	instancer := NewConsulInstancer(server.URL, "test", "v1", "https", "/api", time.Millisecond, server.Client(), zap.NewNop())
+	defer instancer.Stop()
+
+	waitEvent(t, instancer, hasInstances("https://10.0.0.1:8080/api", "https://test.internal:8081/api"))
+
+	consul.set(`[{"Node": {"Address": "10.0.0.3"}, "Service": {"Port": 8080}}]`)
+	waitEvent(t, instancer, hasInstances("https://10.0.0.3:8080/api"))
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+
+	first, second := consul.queries[0].URL, consul.queries[1].URL
+
+	if first.Path != "/v1/health/service/test" || first.Query().Get("passing") != "true" || first.Query().Get("tag") != "v1" {
+		t.Errorf("unexpected query %s", first)
+	}
+
+	// The second query blocked at the index of the first answer.
+	if first.Query().Has("index") || second.Query().Get("index") != "1" {
+		t.Errorf("blocking queries from %s to %s", first, second)
+	}
+}
+
+func TestConsulInstancerKeepsInstancesOnErrors(t *testing.T) {
+	var fail sync.Mutex
+	failing := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail.Lock()
+		defer fail.Unlock()
+
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte(`[{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": 8080}}]`))
+		failing = true
+	}))
+	defer server.Close()
+
+	instancer := NewConsulInstancer(server.URL, "test", "", "http", "", time.Millisecond, server.Client(), zap.NewNop())
+	defer instancer.Stop()
+
+	event := waitEvent(t, instancer, func(event sd.Event) bool { return event.Err != nil })
+
+	if !slices.Equal(event.Instances, []string{"http://10.0.0.1:8080"}) {
+		t.Fatalf("instances %v lost on error", event.Instances)
+	}
+}
+
+func TestEtcdInstancerListsThePrefix(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		query map[string]string
+	)
+
+	values := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v3/kv/range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		mu.Lock()
+		json.NewDecoder(r.Body).Decode(&query)
+		mu.Unlock()
+
+		kvs := []map[string][]byte{}
+
+		for _, value := range values {
+			kvs = append(kvs, map[string][]byte{"value": []byte(value)})
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"kvs": kvs})
+	}))
+	defer server.Close()
+
+	instancer := NewEtcdInstancer(server.URL, "/services/test/", time.Millisecond, server.Client(), zap.NewNop())
+	defer instancer.Stop()
+
+	waitEvent(t, instancer, hasInstances(values...))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	key, _ := base64.StdEncoding.DecodeString(query["key"])
+	end, _ := base64.StdEncoding.DecodeString(query["range_end"])
+
+	if string(key) != "/services/test/" || string(end) != "/services/test0" {
+		t.Fatalf("range from %q to %q", key, end)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		want   []byte
+	}{
+		{"a", []byte("b")},
+		{"/services/", []byte("/services0")},
+		{"a\xff", []byte("b")},
+		{"\xff\xff", []byte{0}},
+	} {
+		if got := prefixEnd(tc.prefix); !slices.Equal(got, tc.want) {
+			t.Errorf("prefixEnd(%q) = %q, want %q", tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, spec := range []string{
+		"consul://localhost:8500",
+		"dns://test.internal",
+		"zookeeper://localhost:2181/test",
+		"consul://localhost:8500/test?interval=-1s",
+	} {
+		if _, err := New(spec, http.DefaultClient, zap.NewNop()); err == nil {
+			t.Errorf("invalid spec %q accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+// NewDnsSrvInstancer polls the SRV records of name, such as
+// "_http._tcp.test.service.consul", and turns each target into
+// scheme://target:port followed by path.
+func NewDnsSrvInstancer(name, scheme, path string, interval time.Duration, logger *zap.Logger) sd.Instancer {
+	var resolver net.Resolver
+
+	return newPoller("dns+srv://"+name, interval, func(ctx context.Context) ([]string, error) {
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		instances := make([]string, 0, len(records))
+
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			instances = append(instances, instanceUrl(scheme, host, strconv.Itoa(int(record.Port)), path))
+		}
+
+		return instances, nil
+	}, logger)
+}
+
+// NewDnsInstancer polls the A and AAAA records of host and turns each address
+// into scheme://address:port followed by path.
+func NewDnsInstancer(host, port, scheme, path string, interval time.Duration, logger *zap.Logger) sd.Instancer {
+	var resolver net.Resolver
+
+	return newPoller("dns://"+net.JoinHostPort(host, port), interval, func(ctx context.Context) ([]string, error) {
+		addrs, err := resolver.LookupHost(ctx, host)
+
+		if err != nil {
+			return nil, err
+		}
+
+		instances := make([]string, 0, len(addrs))
+
+		for _, addr := range addrs {
+			instances = append(instances, instanceUrl(scheme, addr, port, path))
+		}
+
+		return instances, nil
+	}, logger)
+}
+
+func instanceUrl(scheme, host, port, path string) string {
+	return scheme + "://" + net.JoinHostPort(host, port) + path
+}
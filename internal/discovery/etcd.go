@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+// NewEtcdInstancer polls the keys under prefix through the etcd v3 JSON
+// gateway at addr, such as "http://localhost:2379". Each value is an instance
+// URL, as written by registrars following the go-kit etcdv3 layout.
+func NewEtcdInstancer(addr, prefix string, interval time.Duration, client *http.Client, logger *zap.Logger) sd.Instancer {
+	return newPoller("etcd://"+prefix, interval, func(ctx context.Context) ([]string, error) {
+		body, _ := json.Marshal(map[string]string{
+			"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+			"range_end": base64.StdEncoding.EncodeToString(prefixEnd(prefix)),
+		})
+
+		req, err := http.NewRequestWithContext(ctx, "POST", addr+"/v3/kv/range", bytes.NewReader(body))
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		res, err := client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("etcd: unexpected status %s", res.Status)
+		}
+
+		var reply struct {
+			Kvs []struct {
+				Value []byte `json:"value"`
+			} `json:"kvs"`
+		}
+
+		if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+			return nil, fmt.Errorf("etcd: %w", err)
+		}
+
+		instances := make([]string, 0, len(reply.Kvs))
+
+		for _, kv := range reply.Kvs {
+			instances = append(instances, string(kv.Value))
+		}
+
+		return instances, nil
+	}, logger)
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, as etcd expects for range queries.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return []byte{0}
+}
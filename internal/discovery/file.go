@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// NewFileInstancer watches a JSON or YAML file listing instance URLs, either
+// as a plain list or under an "instances" key. The file is reloaded when its
+// modification time changes, checked every interval.
+func NewFileInstancer(path string, interval time.Duration, logger *zap.Logger) sd.Instancer {
+	var (
+		modTime   time.Time
+		instances []string
+	)
+
+	return newPoller("file://"+path, interval, func(_ context.Context) ([]string, error) {
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if info.ModTime().Equal(modTime) {
+			return instances, nil
+		}
+
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := parseInstances(data)
+
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		modTime, instances = info.ModTime(), parsed
+		return instances, nil
+	}, logger)
+}
+
+// parseInstances accepts YAML, and therefore JSON.
+func parseInstances(data []byte) ([]string, error) {
+	var list []string
+
+	if err := yaml.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+
+	var document struct {
+		Instances []string `yaml:"instances"`
+	}
+
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	return document.Instances, nil
+}
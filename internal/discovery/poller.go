@@ -0,0 +1,60 @@
+package discovery
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// poller is an instancer refreshing its instances by calling fetch, every
+// interval or as soon as the previous call returns for sources that block
+// until a change (long polling).
+type poller struct {
+	*Cache
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newPoller(name string, interval time.Duration, fetch func(ctx context.Context) ([]string, error), logger *zap.Logger) *poller {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &poller{
+		Cache:  NewCache(),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	logger = logger.With(zap.String("discovery", name))
+
+	go func() {
+		defer close(p.done)
+
+		for {
+			instances, err := fetch(ctx)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				logger.Warn("service discovery failed", zap.Error(err))
+			}
+
+			p.Update(newEvent(instances, err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *poller) Stop() {
+	p.cancel()
+	<-p.done
+}
@@ -0,0 +1,196 @@
+package otel
+
+import (
+	"context"
+	"gokit-seed/internal/common"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type BaggageConfig struct {
+	// Headers maps inbound request headers to the baggage member they set.
+	Headers map[string]string
+	// Allowed members are copied onto spans and log lines.
+	Allowed []string
+	// Denied members are dropped from inbound baggage, so they are neither
+	// recorded nor forwarded.
+	Denied []string
+	// Inbound baggage over MaxMembers or MaxBytes is dropped as a whole, then
+	// headers are promoted as long as the baggage stays within them.
+	MaxMembers int
+	MaxBytes   int
+}
+
+func DefaultBaggageConfig() BaggageConfig {
+	return BaggageConfig{
+		Headers: map[string]string{
+			"X-Tenant-Id":         "tenant.id",
+			"X-Client-Version":    "client.version",
+			"X-Experiment-Bucket": "experiment.bucket",
+		},
+		Allowed:    []string{"tenant.id", "client.version", "experiment.bucket"},
+		MaxMembers: 16,
+		MaxBytes:   1024,
+	}
+}
+
+// BaggageConfigFromEnv overrides DefaultBaggageConfig with BAGGAGE_HEADERS, a
+// comma separated list of header=member, BAGGAGE_ALLOWED and BAGGAGE_DENIED,
+// comma separated member lists, BAGGAGE_MAX_MEMBERS and BAGGAGE_MAX_BYTES.
+func BaggageConfigFromEnv() BaggageConfig {
+	cfg := DefaultBaggageConfig()
+
+	if value := os.Getenv("BAGGAGE_HEADERS"); value != "" {
+		cfg.Headers = map[string]string{}
+
+		for _, pair := range splitList(value) {
+			header, member, ok := strings.Cut(pair, "=")
+
+			if !ok {
+				panic("BAGGAGE_HEADERS is not a list of header=member: " + pair)
+			}
+
+			cfg.Headers[http.CanonicalHeaderKey(strings.TrimSpace(header))] = strings.TrimSpace(member)
+		}
+	}
+
+	if value := os.Getenv("BAGGAGE_ALLOWED"); value != "" {
+		cfg.Allowed = splitList(value)
+	}
+
+	if value := os.Getenv("BAGGAGE_DENIED"); value != "" {
+		cfg.Denied = splitList(value)
+	}
+
+	cfg.MaxMembers = common.DefaultGetEnvInt("BAGGAGE_MAX_MEMBERS", cfg.MaxMembers)
+	cfg.MaxBytes = common.DefaultGetEnvInt("BAGGAGE_MAX_BYTES", cfg.MaxBytes)
+
+	return cfg
+}
+
+func splitList(value string) []string {
+	items := strings.Split(value, ",")
+
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+
+	return items
+}
+
+var baggageConfig = sync.OnceValue(BaggageConfigFromEnv)
+
+type withBaggage struct {
+	next http.Handler
+}
+
+// WithBaggage enforces the limits of BaggageConfigFromEnv on the inbound
+// baggage, promotes the configured headers into it and adds the allowed
+// members to the server span and to the logger.
+func WithBaggage(next http.Handler) http.Handler {
+	return &withBaggage{next}
+}
+
+func (h *withBaggage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := baggageConfig()
+	ctx := r.Context()
+	logger := common.LoggerFromContext(ctx)
+
+	bag := baggage.FromContext(ctx)
+
+	for _, key := range cfg.Denied {
+		bag = bag.DeleteMember(key)
+	}
+
+	if size := len(bag.String()); bag.Len() > cfg.MaxMembers || size > cfg.MaxBytes {
+		logger.Debug("inbound baggage dropped", zap.Int("members", bag.Len()), zap.Int("bytes", size))
+		bag = baggage.Baggage{}
+	}
+
+	// In order, so that the headers promoted within the limits do not vary.
+	for _, header := range slices.Sorted(maps.Keys(cfg.Headers)) {
+		key := cfg.Headers[header]
+		value := r.Header.Get(header)
+
+		if value == "" || slices.Contains(cfg.Denied, key) {
+			continue
+		}
+
+		member, err := baggage.NewMemberRaw(key, value)
+
+		if err != nil {
+			logger.Debug("baggage header ignored", zap.String("header", header), zap.Error(err))
+			continue
+		}
+
+		promoted, err := bag.SetMember(member)
+
+		if err != nil {
+			logger.Debug("baggage header ignored", zap.String("header", header), zap.Error(err))
+			continue
+		}
+
+		if size := len(promoted.String()); promoted.Len() > cfg.MaxMembers || size > cfg.MaxBytes {
+			logger.Debug("baggage header ignored over the limits", zap.String("header", header), zap.Int("bytes", size))
+			continue
+		}
+
+		bag = promoted
+	}
+
+	attrs := allowedMembers(bag, cfg.Allowed)
+	fields := make([]zap.Field, 0, len(attrs))
+
+	for _, attr := range attrs {
+		fields = append(fields, zap.String(string(attr.Key), attr.Value.AsString()))
+	}
+
+	// The server span started before the headers were promoted.
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+
+	ctx = baggage.ContextWithBaggage(ctx, bag)
+	ctx = common.ContextWithLogger(ctx, logger.With(fields...))
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func allowedMembers(bag baggage.Baggage, allowed []string) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+
+	for _, key := range allowed {
+		if member := bag.Member(key); member.Key() != "" {
+			attrs = append(attrs, attribute.String(key, member.Value()))
+		}
+	}
+
+	return attrs
+}
+
+// baggageSpanProcessor copies the allowed baggage members of the parent
+// context onto every span.
+type baggageSpanProcessor struct {
+	allowed []string
+}
+
+func NewBaggageSpanProcessor(allowed []string) sdktrace.SpanProcessor {
+	return &baggageSpanProcessor{allowed: allowed}
+}
+
+func (p *baggageSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	s.SetAttributes(allowedMembers(baggage.FromContext(parent), p.allowed)...)
+}
+
+func (p *baggageSpanProcessor) OnEnd(sdktrace.ReadOnlySpan) {}
+
+func (p *baggageSpanProcessor) Shutdown(context.Context) error { return nil }
+
+func (p *baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
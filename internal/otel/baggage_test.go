@@ -0,0 +1,93 @@
+package otel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func serveBaggage(t *testing.T, cfg BaggageConfig, inbound string, headers map[string]string) baggage.Baggage {
+	t.Helper()
+
+	previous := baggageConfig
+	baggageConfig = func() BaggageConfig { return cfg }
+	t.Cleanup(func() { baggageConfig = previous })
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if inbound != "" {
+		bag, err := baggage.Parse(inbound)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r = r.WithContext(baggage.ContextWithBaggage(r.Context(), bag))
+	}
+
+	for header, value := range headers {
+		r.Header.Set(header, value)
+	}
+
+	var bag baggage.Baggage
+
+	WithBaggage(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		bag = baggage.FromContext(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), r)
+
+	return bag
+}
+
+func TestWithBaggagePromotesHeaders(t *testing.T) {
+	cfg := DefaultBaggageConfig()
+	cfg.Denied = []string{"secret"}
+
+	bag := serveBaggage(t, cfg, "secret=1,user.plan=pro", map[string]string{"X-Tenant-Id": "acme"})
+
+	if bag.Member("tenant.id").Value() != "acme" || bag.Member("user.plan").Value() != "pro" {
+		t.Fatalf("baggage %s", bag)
+	}
+
+	if bag.Member("secret").Key() != "" {
+		t.Fatal("denied member forwarded")
+	}
+}
+
+func TestWithBaggageKeepsLimitsAfterPromotion(t *testing.T) {
+	cfg := DefaultBaggageConfig()
+	cfg.MaxMembers = 2
+
+	// Within the limits before promotion, the first header fits, not the
+	// second.
+	bag := serveBaggage(t, cfg, "user.plan=pro", map[string]string{
+		"X-Client-Version": "1.2.3",
+		"X-Tenant-Id":      "acme",
+	})
+
+	if bag.Len() != 2 || bag.Member("client.version").Value() != "1.2.3" {
+		t.Fatalf("baggage %s over %d members", bag, cfg.MaxMembers)
+	}
+
+	cfg = DefaultBaggageConfig()
+	cfg.MaxBytes = 40
+
+	bag = serveBaggage(t, cfg, "user.plan=pro", map[string]string{"X-Tenant-Id": strings.Repeat("a", 30)})
+
+	if size := len(bag.String()); size > cfg.MaxBytes || bag.Member("user.plan").Value() != "pro" {
+		t.Fatalf("baggage %s of %d bytes", bag, size)
+	}
+}
+
+func TestWithBaggageDropsOversizedInbound(t *testing.T) {
+	cfg := DefaultBaggageConfig()
+	cfg.MaxMembers = 1
+
+	bag := serveBaggage(t, cfg, "a=1,b=2", map[string]string{"X-Tenant-Id": "acme"})
+
+	if bag.Len() != 1 || bag.Member("tenant.id").Value() != "acme" {
+		t.Fatalf("baggage %s", bag)
+	}
+}
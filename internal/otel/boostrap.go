@@ -3,23 +3,28 @@ package otel
 import (
 	"context"
 	"errors"
+	"gokit-seed/internal/common"
 	"os"
 	"time"
 
+	"go.opentelemetry.io/contrib/propagators/autoprop"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
 	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 // setupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func SetupOTelSdk(lgp *sdklog.LoggerProvider) (func(context.Context) error, error) {
+// Extra metric readers (e.g. a Prometheus exporter) are attached to the meter provider.
+func SetupOTelSdk(lgp *sdklog.LoggerProvider, readers ...metric.Reader) (func(context.Context) error, error) {
 	connectCtx := context.Background()
 	shutdownCtx := context.Background()
 
@@ -44,7 +49,11 @@ func SetupOTelSdk(lgp *sdklog.LoggerProvider) (func(context.Context) error, erro
 	}
 
 	// Set up propagator.
-	prop := newPropagator()
+	prop, err := newPropagator()
+	if err != nil {
+		handleErr(err, shutdownCtx)
+		return shutdown, err
+	}
 	otel.SetTextMapPropagator(prop)
 
 	// Set up trace provider.
@@ -59,7 +68,7 @@ func SetupOTelSdk(lgp *sdklog.LoggerProvider) (func(context.Context) error, erro
 	}
 
 	// Set up meter provider.
-	meterProvider, err := newMeterProvider(connectCtx)
+	meterProvider, err := newMeterProvider(connectCtx, readers...)
 	if err != nil {
 		handleErr(err, shutdownCtx)
 		return shutdown, err
@@ -67,6 +76,11 @@ func SetupOTelSdk(lgp *sdklog.LoggerProvider) (func(context.Context) error, erro
 	if meterProvider != nil {
 		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
 		otel.SetMeterProvider(meterProvider)
+
+		if err := startProcessMetrics(meterProvider); err != nil {
+			handleErr(err, shutdownCtx)
+			return shutdown, err
+		}
 	}
 
 	// Set up logger provider.
@@ -88,11 +102,17 @@ func SetupOTelSdk(lgp *sdklog.LoggerProvider) (func(context.Context) error, erro
 	return shutdown, nil
 }
 
-func newPropagator() propagation.TextMapPropagator {
-	return propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	)
+// newPropagator reads OTEL_PROPAGATORS, a comma separated list of tracecontext,
+// baggage, b3, b3multi, jaeger, xray, ottrace or none, defaulting to
+// tracecontext,baggage.
+func newPropagator() (propagation.TextMapPropagator, error) {
+	names := []string{"tracecontext", "baggage"}
+
+	if value := os.Getenv("OTEL_PROPAGATORS"); value != "" {
+		names = splitList(value)
+	}
+
+	return autoprop.TextMapPropagator(names...)
 }
 
 func newTraceProvider(ctx context.Context) (*trace.TracerProvider, error) {
@@ -108,6 +128,7 @@ func newTraceProvider(ctx context.Context) (*trace.TracerProvider, error) {
 	}
 
 	traceProvider := trace.NewTracerProvider(
+		trace.WithSpanProcessor(NewBaggageSpanProcessor(baggageConfig().Allowed)),
 		trace.WithBatcher(traceExporter,
 			// Default is 5s. Set to 1s for demonstrative purposes.
 			trace.WithBatchTimeout(time.Second)),
@@ -115,26 +136,67 @@ func newTraceProvider(ctx context.Context) (*trace.TracerProvider, error) {
 	return traceProvider, nil
 }
 
-func newMeterProvider(ctx context.Context) (*metric.MeterProvider, error) {
+func newMeterProvider(ctx context.Context, readers ...metric.Reader) (*metric.MeterProvider, error) {
+	var opts []metric.Option
 
-	if metricEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); metricEndpoint == "" {
-		return nil, nil
+	for _, reader := range readers {
+		opts = append(opts, metric.WithReader(reader))
 	}
 
-	metricExporter, err := otlpmetrichttp.New(ctx)
+	if metricEndpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); metricEndpoint != "" {
+		metricExporter, err := otlpmetrichttp.New(ctx)
 
-	if err != nil {
-		return nil, err
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	meterProvider := metric.NewMeterProvider(
-		metric.WithReader(metric.NewPeriodicReader(metricExporter,
+		opts = append(opts, metric.WithReader(metric.NewPeriodicReader(metricExporter,
 			// Default is 1m. Set to 3s for demonstrative purposes.
-			metric.WithInterval(3*time.Second))),
-	)
+			metric.WithInterval(3*time.Second))))
+	}
+
+	if len(opts) == 0 {
+		return nil, nil
+	}
+
+	opts = append(opts, serverMetricViews()...)
+
+	meterProvider := metric.NewMeterProvider(opts...)
 	return meterProvider, nil
 }
 
+// serverMetricViews override the bucket boundaries of the WithRouteMetrics
+// histograms with HTTP_SERVER_DURATION_BUCKETS and HTTP_SERVER_SIZE_BUCKETS.
+func serverMetricViews() []metric.Option {
+	scope := instrumentation.Scope{Name: meterName}
+
+	histogram := func(name string, boundaries []float64) metric.Option {
+		return metric.WithView(metric.NewView(
+			metric.Instrument{Name: name, Scope: scope},
+			metric.Stream{Aggregation: metric.AggregationExplicitBucketHistogram{Boundaries: boundaries}},
+		))
+	}
+
+	var views []metric.Option
+
+	if common.GetEnv("HTTP_SERVER_DURATION_BUCKETS") != nil {
+		views = append(views, histogram(
+			semconv.HTTPServerRequestDurationName,
+			common.DefaultGetEnvFloats("HTTP_SERVER_DURATION_BUCKETS", DurationBuckets),
+		))
+	}
+
+	if common.GetEnv("HTTP_SERVER_SIZE_BUCKETS") != nil {
+		boundaries := common.DefaultGetEnvFloats("HTTP_SERVER_SIZE_BUCKETS", SizeBuckets)
+		views = append(views,
+			histogram(semconv.HTTPServerRequestBodySizeName, boundaries),
+			histogram(semconv.HTTPServerResponseBodySizeName, boundaries),
+		)
+	}
+
+	return views
+}
+
 func NewLoggerProvider(ctx context.Context) (*sdklog.LoggerProvider, error) {
 	var (
 		logExporter sdklog.Exporter
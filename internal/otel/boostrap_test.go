@@ -0,0 +1,69 @@
+package otel
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewPropagator(t *testing.T) {
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	member, _ := baggage.NewMember("tenant", "acme")
+	bag, _ := baggage.New(member)
+	ctx := baggage.ContextWithBaggage(trace.ContextWithSpanContext(context.Background(), spanContext), bag)
+
+	for _, tc := range []struct {
+		env     string
+		headers []string
+		valid   bool
+	}{
+		{"", []string{"Traceparent", "Baggage"}, true},
+		{"tracecontext", []string{"Traceparent"}, true},
+		{" b3multi , baggage ", []string{"X-B3-Traceid", "Baggage"}, true},
+		{"b3", []string{"B3"}, true},
+		{"jaeger", []string{"Uber-Trace-Id"}, true},
+		{"none", nil, true},
+		{"zipkin", nil, false},
+		{"tracecontext,unknown", nil, false},
+	} {
+		t.Setenv("OTEL_PROPAGATORS", tc.env)
+
+		prop, err := newPropagator()
+
+		if (err == nil) != tc.valid {
+			t.Errorf("%q: got %v", tc.env, err)
+			continue
+		}
+
+		if !tc.valid {
+			continue
+		}
+
+		header := http.Header{}
+		prop.Inject(ctx, propagation.HeaderCarrier(header))
+
+		if len(tc.headers) == 0 && len(header) > 0 {
+			t.Errorf("%q: injected %v", tc.env, header)
+		}
+
+		for _, name := range tc.headers {
+			if header.Get(name) == "" {
+				t.Errorf("%q: %s not injected in %v", tc.env, name, header)
+			}
+		}
+
+		// What is injected is extracted back.
+		if len(tc.headers) > 0 && !trace.SpanContextFromContext(prop.Extract(context.Background(), propagation.HeaderCarrier(header))).Equal(spanContext) {
+			t.Errorf("%q: span context does not round trip", tc.env)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gokit
+
+import (
+	"context"
+	"encoding/json"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/otel"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+// DecodeJsonRequest decodes the request body into a T, for servers.
+func DecodeJsonRequest[T any](_ context.Context, r *http.Request) (interface{}, error) {
+	var body T
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// DecodeJsonResponse decodes successful response bodies into a T, for clients.
+// Other responses are returned as a *common.StatusError.
+func DecodeJsonResponse[T any](_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(r.Body, 4096))
+		return nil, &common.StatusError{Code: r.StatusCode, Body: string(message)}
+	}
+
+	var body T
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// NewClient returns an endpoint calling method on target and decoding the
+// response into a Res. It uses otel.DefaultClient and forwards the request id
+// and the deadline of the context, opts may override both.
+func NewClient[Res any](method string, target *url.URL, encode kithttp.EncodeRequestFunc, opts ...kithttp.ClientOption) endpoint.Endpoint {
+	defaults := []kithttp.ClientOption{
+		kithttp.SetClient(otel.DefaultClient),
+		kithttp.ClientBefore(PropagateHeaders),
+	}
+
+	return kithttp.NewClient(
+		method,
+		target,
+		encode,
+		DecodeJsonResponse[Res],
+		append(defaults, opts...)...,
+	).Endpoint()
+}
+
+// PropagateHeaders is a client kithttp.RequestFunc copying the request id and
+// the time left before the deadline of ctx to the outbound request.
+func PropagateHeaders(ctx context.Context, r *http.Request) context.Context {
+	if requestId := common.RequestIdFromContext(ctx); requestId != "" {
+		r.Header.Set("X-Request-Id", requestId)
+	}
+
+	common.SetTimeoutHeader(ctx, r.Header)
+	return ctx
+}
+
+// Invoke calls e and asserts its response type.
+func Invoke[Res any](ctx context.Context, e endpoint.Endpoint, request interface{}) (Res, error) {
+	var zero Res
+
+	response, err := e(ctx, request)
+
+	if err != nil {
+		return zero, err
+	}
+
+	return response.(Res), nil
+}
+
+// EncodeNopRequest sends no body, for GET requests.
+func EncodeNopRequest(context.Context, *http.Request, interface{}) error {
+	return nil
+}
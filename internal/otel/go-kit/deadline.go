@@ -0,0 +1,22 @@
+package gokit
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Deadline is an endpoint.Middleware replacing the error of endpoints that
+// fail once their context is done with the cause of the context, such as the
+// *common.TimeoutError of common.WithTimeout.
+func Deadline(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+
+		if err != nil && ctx.Err() != nil {
+			return response, context.Cause(ctx)
+		}
+
+		return response, err
+	}
+}
@@ -2,14 +2,103 @@ package gokit
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"gokit-seed/internal/common"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/propagation"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var traceResponseEnabled = sync.OnceValue(func() bool {
+	return common.DefaultGetEnvBool("OTEL_TRACE_RESPONSE", false)
+})
+
+// SetTraceResponse exposes the server span to callers when OTEL_TRACE_RESPONSE
+// is true, as a W3C traceresponse header and a Server-Timing traceparent entry
+// which browsers make available to scripts.
+func SetTraceResponse(ctx context.Context, header http.Header) {
+	if !traceResponseEnabled() {
+		return
+	}
+
+	spanContext := trace.SpanContextFromContext(ctx)
+
+	if !spanContext.IsValid() {
+		return
+	}
+
+	value := "00-" + spanContext.TraceID().String() + "-" + spanContext.SpanID().String() + "-" + spanContext.TraceFlags().String()
+	header.Set("traceresponse", value)
+	header.Add("Server-Timing", `traceparent;desc="`+value+`"`)
+}
+
 func DefaultJsonEncoder(ctx context.Context, w http.ResponseWriter, response interface{}) error {
-	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(w.Header()))
+	SetTraceResponse(ctx, w.Header())
 	return json.NewEncoder(w).Encode(response)
 }
+
+type contextKey int
+
+const ifNoneMatchKey contextKey = iota
+
+// IfNoneMatchToContext is a server kithttp.RequestFunc keeping the
+// If-None-Match header for CachingJsonEncoder.
+func IfNoneMatchToContext(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, ifNoneMatchKey, r.Header.Get("If-None-Match"))
+}
+
+// CachingJsonEncoder encodes responses like DefaultJsonEncoder, adding an ETag
+// and a Cache-Control header allowing clients to reuse the response for
+// maxAge, or to revalidate it when zero. Requests whose If-None-Match matches
+// the ETag get a 304 Not Modified without body.
+func CachingJsonEncoder(maxAge time.Duration) kithttp.EncodeResponseFunc {
+	cacheControl := "no-cache"
+
+	if maxAge > 0 {
+		cacheControl = "private, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		body, err := json.Marshal(response)
+
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		SetTraceResponse(ctx, w.Header())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", cacheControl)
+
+		if ifNoneMatch, _ := ctx.Value(ifNoneMatchKey).(string); etagMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, err = w.Write(append(body, '\n'))
+		return err
+	}
+}
+
+// etagMatches uses the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package gokit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetTraceResponse(t *testing.T) {
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0xab},
+		SpanID:     trace.SpanID{0xcd},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+	want := "00-ab000000000000000000000000000000-cd00000000000000-01"
+
+	enabled := traceResponseEnabled
+	defer func() { traceResponseEnabled = enabled }()
+
+	traceResponseEnabled = func() bool { return false }
+	header := http.Header{}
+	SetTraceResponse(ctx, header)
+
+	if len(header) > 0 {
+		t.Fatalf("disabled trace response set %v", header)
+	}
+
+	traceResponseEnabled = func() bool { return true }
+	w := httptest.NewRecorder()
+	w.Header().Set("Server-Timing", "db;dur=5")
+
+	if err := DefaultJsonEncoder(ctx, w, map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Header().Get("traceresponse"); got != want {
+		t.Errorf("traceresponse %q, want %q", got, want)
+	}
+
+	// Other timings are kept.
+	if timings := w.Header().Values("Server-Timing"); len(timings) != 2 || timings[1] != `traceparent;desc="`+want+`"` {
+		t.Errorf("Server-Timing %q", timings)
+	}
+
+	// Requests without a span get no header.
+	header = http.Header{}
+	SetTraceResponse(context.Background(), header)
+
+	if len(header) > 0 {
+		t.Fatalf("trace response without a span: %v", header)
+	}
+}
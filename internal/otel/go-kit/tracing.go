@@ -0,0 +1,62 @@
+package gokit
+
+import (
+	"context"
+	otelutil "gokit-seed/internal/otel"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "gokit-seed/otel/go-kit"
+
+// TraceEndpoint is an endpoint.Middleware creating an internal span named
+// after otel.NameRpc(service, method) around server endpoints.
+func TraceEndpoint(service, method string) endpoint.Middleware {
+	return traceRpc(service, method, trace.SpanKindInternal)
+}
+
+// TraceClient is TraceEndpoint for client endpoints, so that outbound HTTP
+// spans are grouped under the method they implement.
+func TraceClient(service, method string) endpoint.Middleware {
+	return traceRpc(service, method, trace.SpanKindClient)
+}
+
+func traceRpc(service, method string, kind trace.SpanKind) endpoint.Middleware {
+	tracer := otel.Tracer(tracerName)
+	name := otelutil.NameRpc(service, method)
+	attrs := trace.WithAttributes(
+		attribute.String("rpc.system", "go-kit"),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", method),
+	)
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(kind), attrs)
+			defer span.End()
+
+			response, err := next(ctx, request)
+
+			// Business failures are reported in the response, see endpoint.Failer.
+			if failer, ok := response.(endpoint.Failer); ok && err == nil && failer.Failed() != nil {
+				span.SetAttributes(attribute.String("rpc.outcome", "failure"))
+				span.RecordError(failer.Failed())
+				return response, err
+			}
+
+			if err != nil {
+				span.SetAttributes(attribute.String("rpc.outcome", "error"))
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return response, err
+			}
+
+			span.SetAttributes(attribute.String("rpc.outcome", "success"))
+			return response, err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package gokit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// newRecorder makes the global tracer provider record spans. Tracers created
+// from the global provider only switch to the first one set, so the recorder
+// is shared by the tests of the package.
+func newRecorder() *tracetest.SpanRecorder {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+
+	return recorder
+}
+
+// lastSpan returns the last span ended.
+func lastSpan(t *testing.T) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	spans := newRecorder().Ended()
+
+	if len(spans) == 0 {
+		t.Fatal("no span ended")
+	}
+
+	return spans[len(spans)-1]
+}
+
+func attributeOf(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+
+	return ""
+}
+
+type failedResponse struct {
+	err error
+}
+
+func (r failedResponse) Failed() error {
+	return r.err
+}
+
+func TestTraceRpc(t *testing.T) {
+	newRecorder()
+	errCall, errBusiness := errors.New("call failed"), errors.New("not found")
+
+	for _, tc := range []struct {
+		name       string
+		middleware endpoint.Middleware
+		next       endpoint.Endpoint
+		kind       trace.SpanKind
+		outcome    string
+		status     codes.Code
+		events     int
+	}{
+		{"success", TraceEndpoint("Strings", "Reverse"), endpoint.Nop, trace.SpanKindInternal, "success", codes.Unset, 0},
+		{
+			"error",
+			TraceClient("Strings", "Reverse"),
+			func(context.Context, interface{}) (interface{}, error) { return nil, errCall },
+			trace.SpanKindClient, "error", codes.Error, 1,
+		},
+		{
+			"failer",
+			TraceEndpoint("Strings", "Reverse"),
+			func(context.Context, interface{}) (interface{}, error) { return failedResponse{errBusiness}, nil },
+			trace.SpanKindInternal, "failure", codes.Unset, 1,
+		},
+		{
+			"failer without failure",
+			TraceEndpoint("Strings", "Reverse"),
+			func(context.Context, interface{}) (interface{}, error) { return failedResponse{}, nil },
+			trace.SpanKindInternal, "success", codes.Unset, 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var inner trace.SpanContext
+
+			tc.middleware(func(ctx context.Context, request interface{}) (interface{}, error) {
+				inner = trace.SpanContextFromContext(ctx)
+				return tc.next(ctx, request)
+			})(context.Background(), nil)
+
+			span := lastSpan(t)
+
+			if span.Name() != "Strings.Reverse" || span.SpanKind() != tc.kind {
+				t.Errorf("span %s of kind %s", span.Name(), span.SpanKind())
+			}
+
+			if span.SpanContext().SpanID() != inner.SpanID() {
+				t.Error("span not passed to the endpoint")
+			}
+
+			if got := attributeOf(span, "rpc.outcome"); got != tc.outcome {
+				t.Errorf("outcome %q, want %q", got, tc.outcome)
+			}
+
+			if attributeOf(span, "rpc.service") != "Strings" || attributeOf(span, "rpc.method") != "Reverse" {
+				t.Errorf("attributes %v", span.Attributes())
+			}
+
+			if span.Status().Code != tc.status {
+				t.Errorf("status %v, want %v", span.Status(), tc.status)
+			}
+
+			if len(span.Events()) != tc.events {
+				t.Errorf("%d events, want %d recorded errors", len(span.Events()), tc.events)
+			}
+		})
+	}
+}
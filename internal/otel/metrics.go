@@ -0,0 +1,142 @@
+package otel
+
+import (
+	"gokit-seed/internal/common"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const meterName = "gokit-seed/otel"
+
+var (
+	// DurationBuckets are the default boundaries of
+	// http.server.request.duration, in seconds, as recommended by the HTTP
+	// semantic conventions. HTTP_SERVER_DURATION_BUCKETS overrides them.
+	DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+	// SizeBuckets are the default boundaries of the body size histograms, in
+	// bytes. HTTP_SERVER_SIZE_BUCKETS overrides them.
+	SizeBuckets = []float64{0, 128, 512, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+)
+
+var (
+	registerServerMetricsOnce sync.Once
+	requestDuration           metric.Float64Histogram
+	activeServerRequests      metric.Int64UpDownCounter
+	requestBodySize           metric.Int64Histogram
+	responseBodySize          metric.Int64Histogram
+)
+
+func registerServerMetrics() {
+	meter := otel.Meter(meterName)
+
+	requestDuration, _ = meter.Float64Histogram(
+		semconv.HTTPServerRequestDurationName,
+		metric.WithUnit(semconv.HTTPServerRequestDurationUnit),
+		metric.WithDescription(semconv.HTTPServerRequestDurationDescription),
+		metric.WithExplicitBucketBoundaries(DurationBuckets...),
+	)
+
+	activeServerRequests, _ = meter.Int64UpDownCounter(
+		semconv.HTTPServerActiveRequestsName,
+		metric.WithUnit(semconv.HTTPServerActiveRequestsUnit),
+		metric.WithDescription(semconv.HTTPServerActiveRequestsDescription),
+	)
+
+	requestBodySize, _ = meter.Int64Histogram(
+		semconv.HTTPServerRequestBodySizeName,
+		metric.WithUnit(semconv.HTTPServerRequestBodySizeUnit),
+		metric.WithDescription(semconv.HTTPServerRequestBodySizeDescription),
+		metric.WithExplicitBucketBoundaries(SizeBuckets...),
+	)
+
+	responseBodySize, _ = meter.Int64Histogram(
+		semconv.HTTPServerResponseBodySizeName,
+		metric.WithUnit(semconv.HTTPServerResponseBodySizeUnit),
+		metric.WithDescription(semconv.HTTPServerResponseBodySizeDescription),
+		metric.WithExplicitBucketBoundaries(SizeBuckets...),
+	)
+}
+
+type withRouteMetrics struct {
+	route string
+	next  http.Handler
+}
+
+// WithRouteMetrics records the rate, errors and duration of requests to route,
+// the requests in flight and the body sizes, following the HTTP semantic
+// conventions. Measurements are taken in the request context so that sampled
+// spans are attached as exemplars.
+func WithRouteMetrics(route string) common.HandleChain {
+	registerServerMetricsOnce.Do(registerServerMetrics)
+
+	return func(next http.Handler) http.Handler {
+		return &withRouteMetrics{route: route, next: next}
+	}
+}
+
+func (h *withRouteMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := r.Context()
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.HTTPRoute(h.route),
+		semconv.URLScheme(scheme),
+	}
+	active := metric.WithAttributes(attrs...)
+
+	activeServerRequests.Add(ctx, 1, active)
+	defer activeServerRequests.Add(ctx, -1, active)
+
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+	writer := common.NewStatusWriter(w)
+
+	h.next.ServeHTTP(writer, r)
+
+	attrs = append(attrs, semconv.HTTPResponseStatusCode(writer.Status))
+
+	if writer.Status >= 500 {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(strconv.Itoa(writer.Status)))
+	}
+
+	completed := metric.WithAttributes(attrs...)
+
+	requestDuration.Record(ctx, time.Since(start).Seconds(), completed)
+	requestBodySize.Record(ctx, body.n, completed)
+	responseBodySize.Record(ctx, writer.Written, completed)
+}
+
+// NewHandler is otelhttp.NewHandler for routes measured by WithRouteMetrics:
+// it only traces requests, as its metrics would export the
+// http.server.request.duration series of the route twice.
+func NewHandler(handler http.Handler, operation string, opts ...otelhttp.Option) http.Handler {
+	opts = append(opts, otelhttp.WithMeterProvider(noop.NewMeterProvider()))
+	return otelhttp.NewHandler(handler, operation, opts...)
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
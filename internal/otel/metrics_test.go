@@ -0,0 +1,129 @@
+package otel
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// histogramPoint returns the data point of the histogram m with exactly attrs.
+func histogramPoint[N int64 | float64](t *testing.T, m metricdata.Metrics, attrs ...attribute.KeyValue) metricdata.HistogramDataPoint[N] {
+	t.Helper()
+
+	histogram, ok := m.Data.(metricdata.Histogram[N])
+
+	if !ok {
+		t.Fatalf("%s is a %T", m.Name, m.Data)
+	}
+
+	set := attribute.NewSet(attrs...)
+
+	for _, point := range histogram.DataPoints {
+		if point.Attributes.Equals(&set) {
+			return point
+		}
+	}
+
+	t.Fatalf("no %s point with %v", m.Name, attrs)
+	return metricdata.HistogramDataPoint[N]{}
+}
+
+func TestWithRouteMetrics(t *testing.T) {
+	reader := newGlobalReader()
+	route := "/metrics-test"
+
+	var active int64
+	h := WithRouteMetrics(route)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		active = sum(collect(t, reader)[semconv.HTTPServerActiveRequestsName], semconv.HTTPRoute(route))
+
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(append(body, body...))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, route, strings.NewReader("hello")))
+
+	if active != 1 {
+		t.Errorf("%d active requests while serving, want 1", active)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, route, nil))
+
+	metrics := collect(t, reader)
+	created := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(http.MethodPost),
+		semconv.HTTPRoute(route),
+		semconv.URLScheme("http"),
+		semconv.HTTPResponseStatusCode(http.StatusCreated),
+	}
+	failed := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(http.MethodGet),
+		semconv.HTTPRoute(route),
+		semconv.URLScheme("http"),
+		semconv.HTTPResponseStatusCode(http.StatusServiceUnavailable),
+		semconv.ErrorTypeKey.String("503"),
+	}
+
+	if point := histogramPoint[float64](t, metrics[semconv.HTTPServerRequestDurationName], created...); point.Count != 1 ||
+		len(point.Bounds) != len(DurationBuckets) {
+		t.Errorf("duration %+v", point)
+	}
+
+	if point := histogramPoint[float64](t, metrics[semconv.HTTPServerRequestDurationName], failed...); point.Count != 1 {
+		t.Errorf("failed request duration %+v", point)
+	}
+
+	if point := histogramPoint[int64](t, metrics[semconv.HTTPServerRequestBodySizeName], created...); point.Sum != 5 {
+		t.Errorf("request body size %d, want 5", point.Sum)
+	}
+
+	if point := histogramPoint[int64](t, metrics[semconv.HTTPServerResponseBodySizeName], created...); point.Sum != 10 {
+		t.Errorf("response body size %d, want 10", point.Sum)
+	}
+
+	if n := sum(metrics[semconv.HTTPServerActiveRequestsName], semconv.HTTPRoute(route)); n != 0 {
+		t.Errorf("%d active requests once served", n)
+	}
+}
+
+func TestNewHandlerLeavesServerMetricsToWithRouteMetrics(t *testing.T) {
+	reader := newGlobalReader()
+	route := "/handler-test"
+	h := NewHandler(WithRouteMetrics(route)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})), route)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, route, nil))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if strings.HasPrefix(m.Name, "http.server.") && scope.Scope.Name != meterName {
+				t.Errorf("%s also exported by %s", m.Name, scope.Scope.Name)
+			}
+		}
+	}
+
+	if point := histogramPoint[float64](t, collect(t, reader)[semconv.HTTPServerRequestDurationName],
+		semconv.HTTPRequestMethodKey.String(http.MethodGet),
+		semconv.HTTPRoute(route),
+		semconv.URLScheme("http"),
+		semconv.HTTPResponseStatusCode(http.StatusOK),
+	); point.Count != 1 {
+		t.Errorf("duration %+v", point)
+	}
+}
@@ -0,0 +1,111 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/host"
+	"go.opentelemetry.io/contrib/instrumentation/runtime"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+var (
+	processStart  = time.Now()
+	startDuration atomic.Int64
+)
+
+// SetStartDuration records how long the application took to start, exported
+// as app.start.duration once set.
+func SetStartDuration(d time.Duration) {
+	startDuration.Store(int64(d))
+}
+
+// startProcessMetrics registers the Go runtime, host and process metrics, and
+// the build info, uptime and start duration of the application on provider.
+func startProcessMetrics(provider metric.MeterProvider) error {
+	if err := runtime.Start(runtime.WithMeterProvider(provider)); err != nil {
+		return err
+	}
+
+	if err := host.Start(host.WithMeterProvider(provider)); err != nil {
+		return err
+	}
+
+	meter := provider.Meter(meterName)
+
+	buildInfo := buildInfoAttributes()
+
+	_, buildErr := meter.Int64ObservableGauge(
+		"app.build.info",
+		metric.WithDescription("Build of the running binary, always 1"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(1, metric.WithAttributes(buildInfo...))
+			return nil
+		}),
+	)
+
+	_, uptimeErr := meter.Float64ObservableGauge(
+		"app.uptime",
+		metric.WithUnit("s"),
+		metric.WithDescription("Time since the process started"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(time.Since(processStart).Seconds())
+			return nil
+		}),
+	)
+
+	_, startErr := meter.Float64ObservableGauge(
+		"app.start.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Time the application took to start"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			if d := startDuration.Load(); d > 0 {
+				o.Observe(time.Duration(d).Seconds())
+			}
+			return nil
+		}),
+	)
+
+	// Only observed where /proc is available.
+	_, fdErr := meter.Int64ObservableUpDownCounter(
+		semconv.ProcessOpenFileDescriptorCountName,
+		metric.WithUnit(semconv.ProcessOpenFileDescriptorCountUnit),
+		metric.WithDescription(semconv.ProcessOpenFileDescriptorCountDescription),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+				o.Observe(int64(len(fds)))
+			}
+			return nil
+		}),
+	)
+
+	return errors.Join(buildErr, uptimeErr, startErr, fdErr)
+}
+
+func buildInfoAttributes() []attribute.KeyValue {
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return nil
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("version", info.Main.Version),
+		attribute.String("go.version", info.GoVersion),
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision", "vcs.modified":
+			attrs = append(attrs, attribute.String(setting.Key, setting.Value))
+		}
+	}
+
+	return attrs
+}
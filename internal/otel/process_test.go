@@ -0,0 +1,70 @@
+package otel
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func gaugeValue(t *testing.T, m metricdata.Metrics) float64 {
+	t.Helper()
+
+	gauge, ok := m.Data.(metricdata.Gauge[float64])
+
+	if !ok || len(gauge.DataPoints) != 1 {
+		t.Fatalf("%s: %+v", m.Name, m.Data)
+	}
+
+	return gauge.DataPoints[0].Value
+}
+
+func TestStartProcessMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+
+	if err := startProcessMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := collect(t, reader)
+
+	// Not observed until the application started.
+	if _, ok := metrics["app.start.duration"]; ok {
+		t.Error("start duration observed before being set")
+	}
+
+	SetStartDuration(1500 * time.Millisecond)
+	defer SetStartDuration(0)
+
+	metrics = collect(t, reader)
+
+	if got := gaugeValue(t, metrics["app.start.duration"]); got != 1.5 {
+		t.Errorf("start duration %v, want 1.5", got)
+	}
+
+	if got := gaugeValue(t, metrics["app.uptime"]); got <= 0 || got > time.Since(processStart).Seconds() {
+		t.Errorf("uptime %v", got)
+	}
+
+	if n := sum(metrics["app.build.info"], attribute.String("go.version", runtime.Version())); n != 1 {
+		t.Errorf("build info %+v", metrics["app.build.info"].Data)
+	}
+
+	if runtime.GOOS == "linux" {
+		if fds, ok := metrics[semconv.ProcessOpenFileDescriptorCountName].Data.(metricdata.Sum[int64]); !ok ||
+			len(fds.DataPoints) != 1 || fds.DataPoints[0].Value < 3 {
+			t.Errorf("open file descriptors %+v", metrics[semconv.ProcessOpenFileDescriptorCountName].Data)
+		}
+	}
+
+	// Along with the runtime and host instrumentations.
+	for _, name := range []string{"process.runtime.go.goroutines", "process.cpu.time"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("%s not registered", name)
+		}
+	}
+}
@@ -1,23 +1,446 @@
 package otel
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"gokit-seed/internal/common"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
+// DefaultClient is the instrumented client for outbound calls. It uses
+// DefaultTransportConfig until ConfigureDefaultClient applies the environment.
 var DefaultClient = &http.Client{
-	Transport: NewRpcTransport(),
+	Transport: mustNewRpcTransport(DefaultTransportConfig()),
+	Timeout:   DefaultTransportConfig().Timeout,
 }
 
-func NewRpcTransport() *otelhttp.Transport {
+type TransportConfig struct {
+	// Timeout bounds whole calls, including reading the body, never when zero.
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	HTTP2                 bool
+	// Proxy is a proxy URL, "none", or empty to use HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY.
+	Proxy string
+	// CAFile replaces the system roots, CertFile and KeyFile are the client
+	// certificate for mutual TLS.
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		Timeout:             30 * time.Second,
+		DialTimeout:         5 * time.Second,
+		KeepAlive:           30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		HTTP2:               true,
+	}
+}
+
+// TransportConfigFromEnv overrides DefaultTransportConfig with the
+// HTTP_CLIENT_* variables.
+func TransportConfigFromEnv() TransportConfig {
+	cfg := DefaultTransportConfig()
+
+	cfg.Timeout = common.DefaultGetEnvDuration("HTTP_CLIENT_TIMEOUT", cfg.Timeout)
+	cfg.DialTimeout = common.DefaultGetEnvDuration("HTTP_CLIENT_DIAL_TIMEOUT", cfg.DialTimeout)
+	cfg.KeepAlive = common.DefaultGetEnvDuration("HTTP_CLIENT_KEEP_ALIVE", cfg.KeepAlive)
+	cfg.TLSHandshakeTimeout = common.DefaultGetEnvDuration("HTTP_CLIENT_TLS_HANDSHAKE_TIMEOUT", cfg.TLSHandshakeTimeout)
+	cfg.ResponseHeaderTimeout = common.DefaultGetEnvDuration("HTTP_CLIENT_RESPONSE_HEADER_TIMEOUT", cfg.ResponseHeaderTimeout)
+	cfg.IdleConnTimeout = common.DefaultGetEnvDuration("HTTP_CLIENT_IDLE_CONN_TIMEOUT", cfg.IdleConnTimeout)
+	cfg.MaxIdleConns = common.DefaultGetEnvInt("HTTP_CLIENT_MAX_IDLE_CONNS", cfg.MaxIdleConns)
+	cfg.MaxIdleConnsPerHost = common.DefaultGetEnvInt("HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST", cfg.MaxIdleConnsPerHost)
+	cfg.MaxConnsPerHost = common.DefaultGetEnvInt("HTTP_CLIENT_MAX_CONNS_PER_HOST", cfg.MaxConnsPerHost)
+	cfg.HTTP2 = common.DefaultGetEnvBool("HTTP_CLIENT_HTTP2", cfg.HTTP2)
+	cfg.Proxy = os.Getenv("HTTP_CLIENT_PROXY")
+	cfg.CAFile = os.Getenv("HTTP_CLIENT_CA_FILE")
+	cfg.CertFile = os.Getenv("HTTP_CLIENT_CERT_FILE")
+	cfg.KeyFile = os.Getenv("HTTP_CLIENT_KEY_FILE")
+
+	return cfg
+}
+
+// ConfigureDefaultClient applies TransportConfigFromEnv to DefaultClient, call
+// it once the environment is loaded and before any outbound call.
+func ConfigureDefaultClient() error {
+	cfg := TransportConfigFromEnv()
+	transport, err := NewRpcTransport(cfg)
+
+	if err != nil {
+		return err
+	}
+
+	DefaultClient.Transport = transport
+	DefaultClient.Timeout = cfg.Timeout
+	return nil
+}
+
+func NewRpcTransport(cfg TransportConfig) (*otelhttp.Transport, error) {
+	base, err := NewTransport(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return otelhttp.NewTransport(
-		http.DefaultTransport,
+		&pooledTransport{base},
 		otelhttp.WithClientTrace(func(ctx context.Context) *httptrace.ClientTrace {
 			return otelhttptrace.NewClientTrace(ctx)
 		}),
+	), nil
+}
+
+func mustNewRpcTransport(cfg TransportConfig) *otelhttp.Transport {
+	transport, err := NewRpcTransport(cfg)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return transport
+}
+
+// NewTransport returns an http.Transport tuned by cfg, counting its
+// connections for the pool metrics.
+func NewTransport(cfg TransportConfig) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+
+	switch cfg.Proxy {
+	case "":
+	case "none":
+		proxy = nil
+	default:
+		proxyUrl, err := url.Parse(cfg.Proxy)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP client proxy %q: %w", cfg.Proxy, err)
+		}
+
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	registerPoolMetricsOnce.Do(registerPoolMetrics)
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+
+			outcome := "success"
+			if err != nil {
+				outcome = "error"
+			}
+			dials.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+
+			if err != nil {
+				return nil, err
+			}
+
+			openConns.Add(1)
+			return &countedConn{Conn: conn}, nil
+		},
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		ForceAttemptHTTP2:     cfg.HTTP2,
+		ExpectContinueTimeout: time.Second,
+	}, nil
+}
+
+func newTLSConfig(cfg TransportConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+		}
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+var (
+	registerPoolMetricsOnce sync.Once
+	dials                   metric.Int64Counter
+	acquired                metric.Int64Counter
+	openConns               atomic.Int64
+	activeRequests          atomic.Int64
+)
+
+func registerPoolMetrics() {
+	meter := otel.Meter(meterName)
+
+	dials, _ = meter.Int64Counter(
+		"http.client.connection.dials",
+		metric.WithDescription("Connections dialed by outbound HTTP clients"),
 	)
+
+	acquired, _ = meter.Int64Counter(
+		"http.client.connection.acquired",
+		metric.WithDescription("Connections obtained for outbound requests, reused from the pool or not"),
+	)
+
+	// Idle is estimated as the open connections not serving a request, which
+	// undercounts when HTTP/2 multiplexes requests on a connection.
+	meter.Int64ObservableUpDownCounter(
+		"http.client.open_connections",
+		metric.WithDescription("Open outbound HTTP connections by state"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			open, active := openConns.Load(), activeRequests.Load()
+			o.Observe(min(active, open), metric.WithAttributes(attribute.String("http.connection.state", "active")))
+			o.Observe(max(open-active, 0), metric.WithAttributes(attribute.String("http.connection.state", "idle")))
+			return nil
+		}),
+	)
+}
+
+type countedConn struct {
+	net.Conn
+	closed atomic.Bool
+}
+
+func (c *countedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		openConns.Add(-1)
+	}
+
+	return c.Conn.Close()
+}
+
+// pooledTransport counts requests in progress and whether their connection
+// came from the pool.
+type pooledTransport struct {
+	next http.RoundTripper
+}
+
+func (t *pooledTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx := r.Context()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			acquired.Add(ctx, 1, metric.WithAttributes(attribute.Bool("reused", info.Reused)))
+		},
+	}
+
+	activeRequests.Add(1)
+	res, err := t.next.RoundTrip(r.WithContext(httptrace.WithClientTrace(ctx, trace)))
+
+	if err != nil {
+		activeRequests.Add(-1)
+		return nil, err
+	}
+
+	res.Body = &countedBody{ReadCloser: res.Body}
+	return res, nil
+}
+
+// countedBody ends the request once the body is closed.
+type countedBody struct {
+	io.ReadCloser
+	closed atomic.Bool
+}
+
+func (b *countedBody) Close() error {
+	if b.closed.CompareAndSwap(false, true) {
+		activeRequests.Add(-1)
+	}
+
+	return b.ReadCloser.Close()
+}
+
+// CompressClient returns a copy of client compressing request bodies of at
+// least common.DefaultCompressionMinSize bytes with encoding, one of
+// common.Encodings, and accepting responses in every supported coding rather
+// than gzip only. Only use it for upstreams decompressing request bodies, such
+// as those served with common.WithCompression.
+func CompressClient(client *http.Client, encoding string) (*http.Client, error) {
+	if !common.IsSupportedEncoding(encoding) {
+		return nil, fmt.Errorf("%w %q for client compression", common.ErrUnsupportedEncoding, encoding)
+	}
+
+	next := client.Transport
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = &compressingTransport{encoding: encoding, next: next}
+	return &wrapped, nil
+}
+
+type compressingTransport struct {
+	encoding string
+	next     http.RoundTripper
+}
+
+func (t *compressingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+
+	// Callers setting Accept-Encoding decode responses themselves.
+	decode := r.Header.Get("Accept-Encoding") == ""
+
+	if decode {
+		r.Header.Set("Accept-Encoding", strings.Join(common.Encodings, ", "))
+	}
+
+	if r.Body != nil && r.Body != http.NoBody && r.Header.Get("Content-Encoding") == "" {
+		if err := t.compressBody(r); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := t.next.RoundTrip(r)
+
+	if err != nil || !decode {
+		return res, err
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+
+	if encoding == "" || encoding == "identity" || r.Method == http.MethodHead ||
+		res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return res, nil
+	}
+
+	res.Body = &decodedBody{encoding: encoding, body: res.Body}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// compressBody buffers the body of r, like signing does, so that it keeps a
+// length and can be replayed by GetBody.
+func (t *compressingTransport) compressBody(r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if len(body) >= common.DefaultCompressionMinSize {
+		var buf bytes.Buffer
+		encoder, err := common.NewEncoder(t.encoding, &buf)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := encoder.Write(body); err != nil {
+			return err
+		}
+
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+
+		body = buf.Bytes()
+		r.Header.Set("Content-Encoding", t.encoding)
+	}
+
+	r.ContentLength = int64(len(body))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return nil
+}
+
+// decodedBody decompresses a response body on the first read, so that
+// RoundTrip does not wait for it.
+type decodedBody struct {
+	encoding string
+	body     io.ReadCloser
+	decoder  io.ReadCloser
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.decoder == nil && b.err == nil {
+		b.decoder, b.err = common.NewDecoder(b.encoding, b.body, 0)
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	return b.decoder.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if b.decoder != nil {
+		b.decoder.Close()
+	}
+
+	return b.body.Close()
 }
@@ -0,0 +1,423 @@
+package otel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"gokit-seed/internal/common"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestCompressClientRoundTrip(t *testing.T) {
+	large := strings.Repeat(`{"value":"abc"}`, 200)
+	var received, receivedEncoding string
+
+	echo := common.WithCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedEncoding = r.Header.Get("Content-Encoding")
+		echo.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client, err := CompressClient(server.Client(), common.EncodingZstd)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(large))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if receivedEncoding != common.EncodingZstd {
+		t.Errorf("request sent with Content-Encoding %q", receivedEncoding)
+	}
+
+	if received != large {
+		t.Error("request body does not round trip")
+	}
+
+	if !res.Uncompressed || string(body) != large {
+		t.Error("response body not decompressed")
+	}
+}
+
+func TestCompressClientKeepsSmallBodies(t *testing.T) {
+	var receivedEncoding string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedEncoding = r.Header.Get("Content-Encoding")
+	}))
+	defer server.Close()
+
+	client, _ := CompressClient(server.Client(), common.EncodingGzip)
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"value":"abc"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if receivedEncoding != "" {
+		t.Errorf("small body sent with Content-Encoding %q", receivedEncoding)
+	}
+}
+
+func TestCompressClientRejectsUnknownEncodings(t *testing.T) {
+	if _, err := CompressClient(http.DefaultClient, "lz4"); err == nil {
+		t.Fatal("lz4 accepted")
+	}
+}
+
+var (
+	globalReaderOnce sync.Once
+	globalReader     *sdkmetric.ManualReader
+)
+
+// newGlobalReader makes the global meter provider export to a manual reader.
+// Instruments created from the global provider only switch to the first one
+// set, so the reader is shared by the tests of the package.
+func newGlobalReader() *sdkmetric.ManualReader {
+	globalReaderOnce.Do(func() {
+		globalReader = sdkmetric.NewManualReader()
+		otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(globalReader)))
+	})
+
+	return globalReader
+}
+
+// collect returns the metrics of reader by name.
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Metrics {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := map[string]metricdata.Metrics{}
+
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	return metrics
+}
+
+// sum adds up the int64 data points of m having attr.
+func sum(m metricdata.Metrics, attr attribute.KeyValue) (total int64) {
+	var points []metricdata.DataPoint[int64]
+
+	switch data := m.Data.(type) {
+	case metricdata.Sum[int64]:
+		points = data.DataPoints
+	case metricdata.Gauge[int64]:
+		points = data.DataPoints
+	}
+
+	for _, point := range points {
+		if value, ok := point.Attributes.Value(attr.Key); ok && value == attr.Value {
+			total += point.Value
+		}
+	}
+
+	return total
+}
+
+var transportEnv = []string{
+	"HTTP_CLIENT_TIMEOUT", "HTTP_CLIENT_DIAL_TIMEOUT", "HTTP_CLIENT_KEEP_ALIVE", "HTTP_CLIENT_TLS_HANDSHAKE_TIMEOUT",
+	"HTTP_CLIENT_RESPONSE_HEADER_TIMEOUT", "HTTP_CLIENT_IDLE_CONN_TIMEOUT", "HTTP_CLIENT_MAX_IDLE_CONNS",
+	"HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST", "HTTP_CLIENT_MAX_CONNS_PER_HOST", "HTTP_CLIENT_HTTP2", "HTTP_CLIENT_PROXY",
+	"HTTP_CLIENT_CA_FILE", "HTTP_CLIENT_CERT_FILE", "HTTP_CLIENT_KEY_FILE",
+}
+
+func TestTransportConfigFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want func(*TransportConfig)
+	}{
+		{"defaults", nil, func(*TransportConfig) {}},
+		{
+			"timeouts",
+			map[string]string{
+				"HTTP_CLIENT_TIMEOUT":                 "0s",
+				"HTTP_CLIENT_DIAL_TIMEOUT":            "1s",
+				"HTTP_CLIENT_KEEP_ALIVE":              "2s",
+				"HTTP_CLIENT_TLS_HANDSHAKE_TIMEOUT":   "3s",
+				"HTTP_CLIENT_RESPONSE_HEADER_TIMEOUT": "4s",
+				"HTTP_CLIENT_IDLE_CONN_TIMEOUT":       "5s",
+			},
+			func(cfg *TransportConfig) {
+				cfg.Timeout = 0
+				cfg.DialTimeout = time.Second
+				cfg.KeepAlive = 2 * time.Second
+				cfg.TLSHandshakeTimeout = 3 * time.Second
+				cfg.ResponseHeaderTimeout = 4 * time.Second
+				cfg.IdleConnTimeout = 5 * time.Second
+			},
+		},
+		{
+			"pool",
+			map[string]string{
+				"HTTP_CLIENT_MAX_IDLE_CONNS":          "7",
+				"HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST": "8",
+				"HTTP_CLIENT_MAX_CONNS_PER_HOST":      "9",
+				"HTTP_CLIENT_HTTP2":                   "false",
+			},
+			func(cfg *TransportConfig) {
+				cfg.MaxIdleConns = 7
+				cfg.MaxIdleConnsPerHost = 8
+				cfg.MaxConnsPerHost = 9
+				cfg.HTTP2 = false
+			},
+		},
+		{
+			"proxy and certificates",
+			map[string]string{
+				"HTTP_CLIENT_PROXY":     "none",
+				"HTTP_CLIENT_CA_FILE":   "ca.pem",
+				"HTTP_CLIENT_CERT_FILE": "cert.pem",
+				"HTTP_CLIENT_KEY_FILE":  "key.pem",
+			},
+			func(cfg *TransportConfig) {
+				cfg.Proxy = "none"
+				cfg.CAFile = "ca.pem"
+				cfg.CertFile = "cert.pem"
+				cfg.KeyFile = "key.pem"
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range transportEnv {
+				t.Setenv(name, tc.env[name])
+			}
+
+			want := DefaultTransportConfig()
+			tc.want(&want)
+
+			if got := TransportConfigFromEnv(); got != want {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewTransportProxy(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://upstream.test", nil)
+
+	cfg := DefaultTransportConfig()
+	cfg.Proxy = "none"
+
+	if transport, err := NewTransport(cfg); err != nil || transport.Proxy != nil {
+		t.Fatalf("proxy not disabled: %v", err)
+	}
+
+	cfg.Proxy = "http://proxy.test:3128"
+	transport, err := NewTransport(cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proxy, err := transport.Proxy(r); err != nil || proxy.String() != cfg.Proxy {
+		t.Fatalf("proxy %v, %v", proxy, err)
+	}
+
+	cfg.Proxy = "http://proxy.test:port"
+
+	if _, err := NewTransport(cfg); err == nil {
+		t.Fatal("invalid proxy accepted")
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to dir, returning their paths and the certificate.
+func writeClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile, cert
+}
+
+func TestNewTransportMutualTls(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCertificate(t, dir)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(cfg TransportConfig) (string, error) {
+		transport, err := NewTransport(cfg)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer transport.CloseIdleConnections()
+
+		res, err := (&http.Client{Transport: transport}).Get(server.URL)
+
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	cfg := DefaultTransportConfig()
+
+	// The test server certificate is not trusted by the system roots.
+	if _, err := get(cfg); err == nil {
+		t.Fatal("untrusted server certificate accepted")
+	}
+
+	cfg.CAFile = caFile
+
+	if _, err := get(cfg); err == nil {
+		t.Fatal("call without a client certificate succeeded")
+	}
+
+	cfg.CertFile, cfg.KeyFile = certFile, keyFile
+
+	if got, err := get(cfg); err != nil || got != "client" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// Files without certificates are rejected.
+	cfg.CAFile = keyFile
+
+	if _, err := NewTransport(cfg); err == nil {
+		t.Fatal("CA file without certificates accepted")
+	}
+}
+
+func TestRpcTransportPoolMetrics(t *testing.T) {
+	reader := newGlobalReader()
+
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	transport, err := NewRpcTransport(DefaultTransportConfig())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: transport}
+	before := collect(t, reader)
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+
+	after := collect(t, reader)
+	delta := func(name string, attr attribute.KeyValue) int64 {
+		return sum(after[name], attr) - sum(before[name], attr)
+	}
+
+	if n := delta("http.client.connection.dials", attribute.String("outcome", "success")); n != 1 {
+		t.Errorf("%d dials, want 1", n)
+	}
+
+	if n := delta("http.client.connection.acquired", attribute.Bool("reused", true)); n != 1 {
+		t.Errorf("%d reused connections, want 1", n)
+	}
+
+	if n := delta("http.client.open_connections", attribute.String("http.connection.state", "idle")); n != 1 {
+		t.Errorf("%d more idle connections, want 1", n)
+	}
+}
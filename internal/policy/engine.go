@@ -0,0 +1,245 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/common"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.uber.org/zap"
+)
+
+// Policy grants access to callers holding any of Roles and all of Scopes,
+// provided every attribute predicate in Conditions holds.
+type Policy struct {
+	Roles          []string `json:"roles"`
+	Scopes         []string `json:"scopes"`
+	Conditions     []string `json:"conditions"`
+	AllowAnonymous bool     `json:"allow_anonymous"`
+}
+
+// Predicate is an attribute based rule evaluated against the caller and the
+// decoded endpoint request. It returns a reason when access is denied.
+type Predicate func(ctx context.Context, principal *auth.Principal, request interface{}) (bool, string)
+
+// NamedPredicate makes an application specific condition available to
+// policies, provided to fx in the "policy_predicates" group.
+type NamedPredicate struct {
+	Name      string
+	Predicate Predicate
+}
+
+// Engine evaluates named policies. A nil *Engine means authorization is
+// disabled and its middlewares let every request through.
+type Engine struct {
+	policies   map[string]Policy
+	predicates map[string]Predicate
+}
+
+// NewEngine loads policies from the JSON file at POLICY_FILE, named after the
+// route they guard, see common.RouteGroup.PolicyName:
+//
+//	{"strings.reversions": {"roles": ["editor"], "conditions": ["same-tenant"]}}
+//
+// Conditions are the built-in "same-tenant" and "resource-owner" predicates
+// or one of custom. It returns nil when POLICY_FILE is not set.
+func NewEngine(custom []NamedPredicate) (*Engine, error) {
+	path := common.GetEnv("POLICY_FILE")
+
+	if path == nil {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(*path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	policies := map[string]Policy{}
+
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", *path, err)
+	}
+
+	e := &Engine{
+		policies: policies,
+		predicates: map[string]Predicate{
+			"same-tenant":    sameTenant,
+			"resource-owner": resourceOwner,
+		},
+	}
+
+	for _, predicate := range custom {
+		e.predicates[predicate.Name] = predicate.Predicate
+	}
+
+	for name, policy := range policies {
+		for _, condition := range policy.Conditions {
+			if _, ok := e.predicates[condition]; !ok {
+				return nil, fmt.Errorf("parsing %s: policy %q uses unknown condition %q", *path, name, condition)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// Authorize returns an endpoint middleware enforcing the named policy, or an
+// error if it is not defined.
+func (e *Engine) Authorize(name string) (endpoint.Middleware, error) {
+	if e == nil {
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return next
+		}, nil
+	}
+
+	policy, ok := e.policies[name]
+
+	if !ok {
+		return nil, fmt.Errorf("policy %q is not defined", name)
+	}
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal := auth.PrincipalFromContext(ctx)
+			allowed, reason := e.evaluate(ctx, policy, principal, request)
+
+			audit(ctx, name, principal, allowed, reason)
+
+			if !allowed {
+				return nil, newError(name, principal, reason)
+			}
+
+			return next(ctx, request)
+		}
+	}, nil
+}
+
+func (e *Engine) evaluate(ctx context.Context, policy Policy, principal *auth.Principal, request interface{}) (bool, string) {
+	if principal == nil {
+		if policy.AllowAnonymous {
+			return true, "anonymous access allowed"
+		}
+		return false, "caller is not authenticated"
+	}
+
+	if len(policy.Roles) > 0 && !slices.ContainsFunc(policy.Roles, principal.HasRole) {
+		return false, "caller has none of the required roles"
+	}
+
+	for _, scope := range policy.Scopes {
+		if !principal.HasScope(scope) {
+			return false, "caller is missing scope " + scope
+		}
+	}
+
+	for _, condition := range policy.Conditions {
+		if ok, reason := e.predicates[condition](ctx, principal, request); !ok {
+			return false, reason
+		}
+	}
+
+	return true, "policy satisfied"
+}
+
+func audit(ctx context.Context, name string, principal *auth.Principal, allowed bool, reason string) {
+	decision := "deny"
+	if allowed {
+		decision = "allow"
+	}
+
+	subject := ""
+	if principal != nil {
+		subject = principal.Subject
+	}
+
+	common.LoggerFromContext(ctx).Named("audit").Info(
+		"authorization decision",
+		zap.String("policy", name),
+		zap.String("subject", subject),
+		zap.String("decision", decision),
+		zap.String("reason", reason),
+	)
+}
+
+// TenantScoped is implemented by requests targeting a single tenant.
+type TenantScoped interface {
+	Tenant() string
+}
+
+// Owned is implemented by requests targeting a resource with an owner.
+type Owned interface {
+	Owner() string
+}
+
+func sameTenant(_ context.Context, principal *auth.Principal, request interface{}) (bool, string) {
+	scoped, ok := request.(TenantScoped)
+
+	if !ok {
+		return false, "request is not tenant scoped"
+	}
+
+	if principal.Tenant == "" || principal.Tenant != scoped.Tenant() {
+		return false, "caller belongs to another tenant"
+	}
+
+	return true, ""
+}
+
+func resourceOwner(_ context.Context, principal *auth.Principal, request interface{}) (bool, string) {
+	owned, ok := request.(Owned)
+
+	if !ok {
+		return false, "request has no resource owner"
+	}
+
+	if principal.Subject != owned.Owner() {
+		return false, "caller does not own the resource"
+	}
+
+	return true, ""
+}
+
+// Error is returned when a policy denies access. It is rendered as a JSON 403
+// (or 401 for anonymous callers) by kithttp.DefaultErrorEncoder.
+type Error struct {
+	Policy string `json:"policy"`
+	Reason string `json:"reason"`
+	code   int
+}
+
+func newError(name string, principal *auth.Principal, reason string) *Error {
+	code := http.StatusForbidden
+	if principal == nil {
+		code = http.StatusUnauthorized
+	}
+
+	return &Error{Policy: name, Reason: reason, code: code}
+}
+
+func (e *Error) Error() string {
+	return "access denied by policy " + e.Policy + ": " + e.Reason
+}
+
+func (e *Error) StatusCode() int {
+	return e.code
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	kind := "forbidden"
+	if e.code == http.StatusUnauthorized {
+		kind = "unauthorized"
+	}
+
+	return json.Marshal(map[string]string{
+		"error":  kind,
+		"policy": e.Policy,
+		"reason": e.Reason,
+	})
+}
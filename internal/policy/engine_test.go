@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"gokit-seed/internal/auth"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+func newTestEngine(t *testing.T, policies string, custom ...NamedPredicate) (*Engine, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "policies.json")
+
+	if err := os.WriteFile(path, []byte(policies), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("POLICY_FILE", path)
+	return NewEngine(custom)
+}
+
+type tenantRequest string
+
+func (r tenantRequest) Tenant() string {
+	return string(r)
+}
+
+func TestAuthorize(t *testing.T) {
+	e, err := newTestEngine(t, `{
+		"strings.reversions": {"roles": ["editor", "admin"], "scopes": ["strings:reverse"], "conditions": ["same-tenant"]},
+		"strings.greetings": {"allow_anonymous": true}
+	}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reverse, err := e.Authorize("strings.reversions")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor := &auth.Principal{Subject: "alice", Roles: []string{"editor"}, Scopes: []string{"strings:reverse"}, Tenant: "acme"}
+
+	for _, tc := range []struct {
+		name      string
+		principal *auth.Principal
+		request   interface{}
+		code      int
+	}{
+		{"allowed", editor, tenantRequest("acme"), 0},
+		{"anonymous", nil, tenantRequest("acme"), http.StatusUnauthorized},
+		{"missing role", &auth.Principal{Subject: "bob", Scopes: []string{"strings:reverse"}, Tenant: "acme"}, tenantRequest("acme"), http.StatusForbidden},
+		{"missing scope", &auth.Principal{Subject: "bob", Roles: []string{"admin"}, Tenant: "acme"}, tenantRequest("acme"), http.StatusForbidden},
+		{"other tenant", editor, tenantRequest("globex"), http.StatusForbidden},
+		{"not tenant scoped", editor, nil, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if tc.principal != nil {
+				ctx = auth.ContextWithPrincipal(ctx, tc.principal)
+			}
+
+			_, err := reverse(endpoint.Nop)(ctx, tc.request)
+
+			var policyErr *Error
+			switch {
+			case tc.code == 0 && err != nil:
+				t.Fatalf("denied: %v", err)
+			case tc.code != 0 && !errors.As(err, &policyErr):
+				t.Fatalf("allowed, want %d", tc.code)
+			case tc.code != 0 && policyErr.StatusCode() != tc.code:
+				t.Fatalf("denied with %d, want %d", policyErr.StatusCode(), tc.code)
+			}
+		})
+	}
+
+	greetings, _ := e.Authorize("strings.greetings")
+
+	if _, err := greetings(endpoint.Nop)(context.Background(), nil); err != nil {
+		t.Fatalf("anonymous access denied: %v", err)
+	}
+}
+
+func TestUndefinedPoliciesAreErrors(t *testing.T) {
+	e, err := newTestEngine(t, `{}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.Authorize("strings.reversions"); err == nil {
+		t.Fatal("undefined policy accepted")
+	}
+
+	if _, err := newTestEngine(t, `{"strings.reversions": {"conditions": ["weekday"]}}`); err == nil {
+		t.Fatal("unknown condition accepted")
+	}
+}
+
+func TestCustomPredicates(t *testing.T) {
+	e, err := newTestEngine(t, `{"strings.reversions": {"conditions": ["weekday"]}}`, NamedPredicate{
+		Name: "weekday",
+		Predicate: func(context.Context, *auth.Principal, interface{}) (bool, string) {
+			return false, "closed on weekends"
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reverse, err := e.Authorize("strings.reversions")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := auth.ContextWithPrincipal(context.Background(), &auth.Principal{Subject: "alice"})
+
+	var policyErr *Error
+	if _, err := reverse(endpoint.Nop)(ctx, nil); !errors.As(err, &policyErr) || policyErr.Reason != "closed on weekends" {
+		t.Fatalf("custom predicate ignored: %v", err)
+	}
+}
+
+func TestDisabledEngineAllows(t *testing.T) {
+	var e *Engine
+	authorize, err := e.Authorize("anything")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authorize(endpoint.Nop)(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
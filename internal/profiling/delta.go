@@ -0,0 +1,77 @@
+package profiling
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/google/pprof/profile"
+)
+
+// deltaProfile turns the cumulative profiles of the runtime, such as the
+// allocations of the heap profile or the mutex and block profiles, into the
+// difference with their previous snapshot, as Pyroscope expects profiles
+// covering a single interval. The first snapshot covers the process lifetime.
+type deltaProfile struct {
+	// cumulative lists the indexes of the sample values to subtract, the
+	// others, such as the memory in use of heap profiles, being gauges.
+	cumulative []int
+	previous   *profile.Profile
+}
+
+func newDeltaProfile(cumulative ...int) *deltaProfile {
+	return &deltaProfile{cumulative: cumulative}
+}
+
+// deltaProfiles returns the delta of the cumulative profile types, nil for
+// the others.
+func deltaProfiles() map[string]*deltaProfile {
+	return map[string]*deltaProfile{
+		// alloc_objects and alloc_space, but not inuse_objects and inuse_space.
+		ProfileHeap: newDeltaProfile(0, 1),
+		// contentions and delay.
+		ProfileMutex: newDeltaProfile(0, 1),
+		ProfileBlock: newDeltaProfile(0, 1),
+	}
+}
+
+// Convert takes a gzipped pprof profile and returns it with the values of
+// its samples replaced by their delta, dropping samples left empty.
+func (d *deltaProfile) Convert(data []byte) ([]byte, error) {
+	current, err := profile.ParseData(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	delta := current
+
+	if d.previous != nil {
+		// Merging the previous snapshot negated subtracts it, gauges
+		// excepted, and drops the samples left at zero.
+		previous := d.previous.Copy()
+		ratios := make([]float64, len(previous.SampleType))
+
+		for i := range ratios {
+			if slices.Contains(d.cumulative, i) {
+				ratios[i] = -1
+			}
+		}
+
+		if err := previous.ScaleN(ratios); err != nil {
+			return nil, err
+		}
+
+		if delta, err = profile.Merge([]*profile.Profile{current, previous}); err != nil {
+			return nil, err
+		}
+	}
+
+	d.previous = current
+
+	var buf bytes.Buffer
+	if err := delta.Compact().Write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
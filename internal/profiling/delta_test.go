@@ -0,0 +1,149 @@
+package profiling
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// heapProfile returns a gzipped heap profile of a sample per stack of
+// addresses, with location ids numbered from firstId.
+func heapProfile(t *testing.T, firstId uint64, stacks [][]uint64, values [][]int64) []byte {
+	t.Helper()
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:     512 * 1024,
+	}
+	locations := map[uint64]*profile.Location{}
+
+	for i, stack := range stacks {
+		sample := &profile.Sample{Value: values[i]}
+
+		for _, address := range stack {
+			location := locations[address]
+
+			if location == nil {
+				location = &profile.Location{ID: firstId + uint64(len(locations)), Address: address}
+				locations[address] = location
+				p.Location = append(p.Location, location)
+			}
+
+			sample.Location = append(sample.Location, location)
+		}
+
+		p.Sample = append(p.Sample, sample)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// samplesByLeaf returns the values of the samples of a gzipped profile by
+// the address of their leaf location.
+func samplesByLeaf(t *testing.T, data []byte) map[uint64][]int64 {
+	t.Helper()
+
+	p, err := profile.ParseData(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := map[uint64][]int64{}
+
+	for _, s := range p.Sample {
+		samples[s.Location[0].Address] = s.Value
+	}
+
+	return samples
+}
+
+func TestDeltaProfileSubtractsThePreviousSnapshot(t *testing.T) {
+	d := newDeltaProfile(0, 1)
+	stacks := [][]uint64{{0x1000, 0x2000}, {0x3000}}
+
+	first, err := d.Convert(heapProfile(t, 1, stacks, [][]int64{{2, 200, 1, 100}, {1, 10, 0, 0}}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := samplesByLeaf(t, first)[0x1000]; len(got) != 4 || got[0] != 2 || got[1] != 200 {
+		t.Fatalf("first snapshot %v", got)
+	}
+
+	// Location ids are renumbered between snapshots, the stack is the same.
+	second, err := d.Convert(heapProfile(t, 10, stacks, [][]int64{{5, 500, 1, 100}, {1, 10, 0, 0}}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := samplesByLeaf(t, second)
+
+	// Gauges, such as the memory in use, are kept as is.
+	if got := samples[0x1000]; len(got) != 4 || got[0] != 3 || got[1] != 300 || got[2] != 1 || got[3] != 100 {
+		t.Errorf("delta %v", got)
+	}
+
+	if _, ok := samples[0x3000]; ok {
+		t.Error("unchanged sample kept")
+	}
+}
+
+// sink keeps allocations reachable.
+var sink []byte
+
+func TestDeltaProfileOfTheRuntime(t *testing.T) {
+	d := deltaProfiles()[ProfileHeap]
+	allocated := func() (total int64) {
+		runtime.GC()
+
+		var buf bytes.Buffer
+		if err := pprof.Lookup(ProfileHeap).WriteTo(&buf, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := d.Convert(buf.Bytes())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, values := range samplesByLeaf(t, data) {
+			total += values[1]
+		}
+
+		return total
+	}
+
+	const size = 64 << 20
+
+	allocated()
+	sink = make([]byte, size)
+
+	if got := allocated(); got < size {
+		t.Fatalf("allocated %d bytes, want at least %d", got, size)
+	}
+
+	// The allocation is only in the snapshot following it.
+	if got := allocated(); got >= size {
+		t.Fatalf("allocated %d bytes again", got)
+	}
+
+	sink = nil
+}
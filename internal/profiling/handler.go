@@ -0,0 +1,38 @@
+package profiling
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type withTraceLabels struct {
+	next http.Handler
+}
+
+// WithTraceLabels tags the goroutines serving a request with pprof labels
+// carrying the current trace and span ids, so that CPU samples can be linked
+// back to traces.
+func WithTraceLabels(next http.Handler) http.Handler {
+	return &withTraceLabels{next}
+}
+
+func (h *withTraceLabels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	spanContext := trace.SpanContextFromContext(r.Context())
+
+	if !spanContext.IsValid() {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	labels := pprof.Labels(
+		"trace_id", spanContext.TraceID().String(),
+		"span_id", spanContext.SpanID().String(),
+	)
+
+	pprof.Do(r.Context(), labels, func(ctx context.Context) {
+		h.next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
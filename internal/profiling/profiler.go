@@ -0,0 +1,370 @@
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/otel"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	ProfileCpu       = "cpu"
+	ProfileHeap      = "heap"
+	ProfileGoroutine = "goroutine"
+	ProfileMutex     = "mutex"
+	ProfileBlock     = "block"
+)
+
+// Profiler periodically captures runtime profiles and pushes them to a
+// Pyroscope compatible ingest API and/or writes them to a local directory.
+type Profiler struct {
+	logger        *zap.Logger
+	client        *http.Client
+	interval      time.Duration
+	types         []string
+	pushUrl       *string
+	dir           *string
+	appName       string
+	tags          map[string]string
+	stop          chan struct{}
+	done          chan struct{}
+	mutexRate     int
+	blockRate     int
+	prevMutexRate int
+	deltas        map[string]*deltaProfile
+	// yield interrupts the CPU profile for Yield, which waits for it to
+	// be stopped, and yielding pauses the next ones.
+	yield    chan chan struct{}
+	yielding atomic.Int32
+}
+
+// NewProfiler returns nil unless PROFILING_ENABLED is true.
+func NewProfiler(lc fx.Lifecycle, logger *zap.Logger) (*Profiler, error) {
+	if !common.DefaultGetEnvBool("PROFILING_ENABLED", false) {
+		return nil, nil
+	}
+
+	types := []string{ProfileCpu, ProfileHeap, ProfileGoroutine, ProfileMutex, ProfileBlock}
+	if value := common.GetEnv("PROFILING_TYPES"); value != nil {
+		types = strings.Split(*value, ",")
+	}
+
+	res, err := resource.New(context.Background(), resource.WithFromEnv(), resource.WithTelemetrySDK())
+
+	if err != nil {
+		return nil, err
+	}
+
+	appName, tags := resourceLabels(res)
+
+	p := &Profiler{
+		logger:    logger,
+		client:    otel.DefaultClient,
+		interval:  common.DefaultGetEnvDuration("PROFILING_INTERVAL", 15*time.Second),
+		types:     types,
+		pushUrl:   common.GetEnv("PROFILING_PYROSCOPE_URL"),
+		dir:       common.GetEnv("PROFILING_DIR"),
+		appName:   appName,
+		tags:      tags,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		mutexRate: 5,
+		blockRate: int(time.Millisecond),
+		deltas:    deltaProfiles(),
+		yield:     make(chan chan struct{}),
+	}
+
+	if p.pushUrl == nil && p.dir == nil {
+		return nil, fmt.Errorf("profiling is enabled but neither PROFILING_PYROSCOPE_URL nor PROFILING_DIR is set")
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(_ context.Context) error {
+			p.start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logger.Info("stopping profiler")
+			return p.shutdown(ctx)
+		},
+	})
+
+	return p, nil
+}
+
+func (p *Profiler) start() {
+	if p.enabled(ProfileMutex) {
+		p.prevMutexRate = runtime.SetMutexProfileFraction(p.mutexRate)
+	}
+
+	if p.enabled(ProfileBlock) {
+		runtime.SetBlockProfileRate(p.blockRate)
+	}
+
+	go p.run()
+}
+
+func (p *Profiler) shutdown(ctx context.Context) error {
+	close(p.stop)
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if p.enabled(ProfileMutex) {
+		runtime.SetMutexProfileFraction(p.prevMutexRate)
+	}
+
+	if p.enabled(ProfileBlock) {
+		runtime.SetBlockProfileRate(0)
+	}
+
+	return nil
+}
+
+func (p *Profiler) run() {
+	defer close(p.done)
+
+	for {
+		from := time.Now()
+
+		var cpu bytes.Buffer
+		cpuRunning := false
+
+		if p.enabled(ProfileCpu) && p.yielding.Load() == 0 {
+			if err := pprof.StartCPUProfile(&cpu); err != nil {
+				p.logger.Warn("failed to start cpu profile", zap.Error(err))
+			} else {
+				cpuRunning = true
+			}
+		}
+
+		stopped := false
+		var yielded chan struct{}
+
+		select {
+		case <-time.After(p.interval):
+		case yielded = <-p.yield:
+		case <-p.stop:
+			stopped = true
+		}
+
+		if cpuRunning {
+			pprof.StopCPUProfile()
+		}
+
+		if yielded != nil {
+			close(yielded)
+		}
+
+		until := time.Now()
+
+		if cpuRunning {
+			p.export(ProfileCpu, cpu.Bytes(), from, until)
+		}
+
+		for _, profileType := range p.types {
+			if profileType == ProfileCpu {
+				continue
+			}
+
+			profile := pprof.Lookup(profileType)
+
+			if profile == nil {
+				p.logger.Warn("unknown profile type", zap.String("type", profileType))
+				continue
+			}
+
+			var buf bytes.Buffer
+			if err := profile.WriteTo(&buf, 0); err != nil {
+				p.logger.Warn("failed to write profile", zap.String("type", profileType), zap.Error(err))
+				continue
+			}
+
+			data := buf.Bytes()
+
+			if delta := p.deltas[profileType]; delta != nil {
+				var err error
+
+				if data, err = delta.Convert(data); err != nil {
+					p.logger.Warn("failed to compute profile delta", zap.String("type", profileType), zap.Error(err))
+					continue
+				}
+			}
+
+			p.export(profileType, data, from, until)
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// Yield wraps the handler of an on-demand CPU profile, such as
+// /debug/pprof/profile, which would fail while the continuous CPU profile
+// runs as only one can at a time. The continuous profile is cut short and
+// paused until the handler returns.
+func (p *Profiler) Yield(next http.Handler) http.Handler {
+	if p == nil || !p.enabled(ProfileCpu) {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.yielding.Add(1)
+		defer p.yielding.Add(-1)
+
+		yielded := make(chan struct{})
+
+		select {
+		case p.yield <- yielded:
+			<-yielded
+		case <-p.done:
+		case <-r.Context().Done():
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Profiler) export(profileType string, data []byte, from, until time.Time) {
+	if p.dir != nil {
+		name := fmt.Sprintf("%s-%s-%d.pb.gz", p.appName, profileType, until.Unix())
+
+		if err := os.WriteFile(filepath.Join(*p.dir, name), data, 0o644); err != nil {
+			p.logger.Warn("failed to write profile", zap.String("type", profileType), zap.Error(err))
+		}
+	}
+
+	if p.pushUrl != nil {
+		if err := p.push(profileType, data, from, until); err != nil {
+			p.logger.Warn("failed to push profile", zap.String("type", profileType), zap.Error(err))
+		}
+	}
+}
+
+// push uploads a pprof encoded profile to the Pyroscope /ingest endpoint.
+func (p *Profiler) push(profileType string, data []byte, from, until time.Time) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+
+	if err != nil {
+		return err
+	}
+
+	part.Write(data)
+	form.Close()
+
+	query := url.Values{}
+	query.Set("name", p.appName+"."+profileType+p.encodeTags())
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("format", "pprof")
+	query.Set("spyName", "gospy")
+
+	if profileType == ProfileCpu {
+		query.Set("sampleRate", "100")
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(*p.pushUrl, "/")+"/ingest?"+query.Encode(), &body)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	res, err := p.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
+
+func (p *Profiler) encodeTags() string {
+	if len(p.tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(p.tags))
+	for key := range p.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+p.tags[key])
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (p *Profiler) enabled(profileType string) bool {
+	for _, t := range p.types {
+		if t == profileType {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	invalidTagKeyChars   = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	invalidTagValueChars = regexp.MustCompile(`[^a-zA-Z0-9_.\-]`)
+)
+
+// resourceLabels derives the application name and the tags attached to every
+// profile from the service resource attributes.
+func resourceLabels(res *resource.Resource) (string, map[string]string) {
+	appName := "unknown_service"
+	tags := map[string]string{}
+
+	for _, attr := range res.Attributes() {
+		key := string(attr.Key)
+		value := invalidTagValueChars.ReplaceAllString(attr.Value.Emit(), "_")
+
+		if key == "service.name" {
+			appName = value
+			continue
+		}
+
+		if strings.HasPrefix(key, "telemetry.sdk.") {
+			continue
+		}
+
+		tags[invalidTagKeyChars.ReplaceAllString(key, "_")] = value
+	}
+
+	return appName, tags
+}
@@ -0,0 +1,46 @@
+package profiling
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestYieldInterruptsTheCpuProfile(t *testing.T) {
+	dir := t.TempDir()
+	p := &Profiler{
+		logger:   zap.NewNop(),
+		interval: time.Minute,
+		types:    []string{ProfileCpu},
+		dir:      &dir,
+		appName:  "test",
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		yield:    make(chan chan struct{}),
+	}
+
+	go p.run()
+	defer func() {
+		close(p.stop)
+		<-p.done
+	}()
+
+	w := httptest.NewRecorder()
+	p.Yield(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := pprof.StartCPUProfile(io.Discard); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		pprof.StopCPUProfile()
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/profile", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("on-demand profile failed: %s", w.Body)
+	}
+}
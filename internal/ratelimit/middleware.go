@@ -0,0 +1,215 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/redis"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// KeyFunc identifies the client a request is accounted to.
+type KeyFunc func(r *http.Request) string
+
+func ByIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// ByApiKey accounts requests to the key they were signed or authenticated
+// with by auth.Keyring.VerifySignature. Other requests have no key, they are
+// only limited by client IP.
+func ByApiKey(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil && principal.HasRole("service") {
+		return "sub:" + principal.Subject
+	}
+
+	return ""
+}
+
+// ByPrincipal accounts requests to their authenticated subject. Other
+// requests have no key, they are only limited by client IP.
+func ByPrincipal(r *http.Request) string {
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		return "sub:" + principal.Subject
+	}
+
+	return ""
+}
+
+// Limiter applies per route token buckets to inbound requests. A nil
+// *Limiter means rate limiting is disabled.
+type Limiter struct {
+	store Store
+	// caller keys authenticated requests, nil when requests are only
+	// limited by client IP.
+	caller    KeyFunc
+	ipFactor  int
+	overrides map[string]Limit
+}
+
+// NewLimiter configures inbound rate limiting from the environment:
+// RATE_LIMIT_STORE selects the "memory" or "redis" store (disabled when
+// unset), RATE_LIMIT_KEY the client key ("ip", "apikey" or "principal"),
+// RATE_LIMIT_IP_FACTOR how much looser the client IP limit is when requests
+// are accounted to callers, and RATE_LIMIT_ROUTES overrides route limits as
+// "route=rate:burst,...".
+func NewLimiter(client *redis.Client) (*Limiter, error) {
+	l := &Limiter{
+		ipFactor:  common.DefaultGetEnvInt("RATE_LIMIT_IP_FACTOR", 10),
+		overrides: map[string]Limit{},
+	}
+
+	if l.ipFactor < 1 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_IP_FACTOR %d", l.ipFactor)
+	}
+
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "":
+		return nil, nil
+	case "memory":
+		l.store = NewMemoryStore(100_000)
+	case "redis":
+		if client == nil {
+			return nil, fmt.Errorf("RATE_LIMIT_STORE is redis but REDIS_URL is not set")
+		}
+		l.store = NewRedisStore(client, "ratelimit:")
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_STORE %q", store)
+	}
+
+	switch key := os.Getenv("RATE_LIMIT_KEY"); key {
+	case "", "ip":
+	case "apikey":
+		l.caller = ByApiKey
+	case "principal":
+		l.caller = ByPrincipal
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_KEY %q", key)
+	}
+
+	if routes := common.GetEnv("RATE_LIMIT_ROUTES"); routes != nil {
+		for _, entry := range strings.Split(*routes, ",") {
+			route, params, ok1 := strings.Cut(strings.TrimSpace(entry), "=")
+			rate, burst, ok2 := strings.Cut(params, ":")
+			r, err1 := strconv.ParseFloat(rate, 64)
+			b, err2 := strconv.Atoi(burst)
+
+			if !ok1 || !ok2 || err1 != nil || err2 != nil || r <= 0 || b <= 0 {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q", entry)
+			}
+
+			l.overrides[route] = Limit{Rate: r, Burst: b}
+		}
+	}
+
+	return l, nil
+}
+
+// Route returns a common.HandleChain limiting requests to route by client
+// IP, using limit unless RATE_LIMIT_ROUTES overrides it. It goes before
+// authentication, so that floods are rejected without verifying them, and
+// never trusts the credentials of requests: a new forged token on every
+// request would otherwise get a full bucket each time. When requests are
+// accounted to callers by Authenticated, the client IP limit is
+// RATE_LIMIT_IP_FACTOR times looser as callers may share an address.
+func (l *Limiter) Route(route string, limit Limit) common.HandleChain {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+
+		limit := l.limit(route, limit)
+
+		if l.caller != nil {
+			limit = Limit{Rate: limit.Rate * float64(l.ipFactor), Burst: limit.Burst * l.ipFactor}
+		}
+
+		return &withRateLimit{l.store, ByIp, route, limit, next}
+	}
+}
+
+// Authenticated returns a common.HandleChain limiting requests to route by
+// the caller RATE_LIMIT_KEY accounts them to, once verified. It goes within
+// auth.Verifier.Authenticate and auth.Keyring.VerifySignature; requests
+// without a caller are only limited by Route.
+func (l *Limiter) Authenticated(route string, limit Limit) common.HandleChain {
+	return func(next http.Handler) http.Handler {
+		if l == nil || l.caller == nil {
+			return next
+		}
+
+		return &withRateLimit{l.store, l.caller, route, l.limit(route, limit), next}
+	}
+}
+
+func (l *Limiter) limit(route string, limit Limit) Limit {
+	if override, ok := l.overrides[route]; ok {
+		return override
+	}
+
+	return limit
+}
+
+type withRateLimit struct {
+	store Store
+	key   KeyFunc
+	route string
+	limit Limit
+	next  http.Handler
+}
+
+func (h *withRateLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := h.key(r)
+
+	if key == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	result, err := h.store.Take(ctx, h.route+":"+key, h.limit)
+
+	if err != nil {
+		// Fail open, an unavailable store should not take the service down.
+		common.LoggerFromContext(ctx).Warn("rate limit store failed", zap.Error(err))
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+	if result.Allowed {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "rate_limited",
+		"message": fmt.Sprintf("too many requests, retry in %ds", ceilSeconds(result.RetryAfter)),
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
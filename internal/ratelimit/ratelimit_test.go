@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"context"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/redis"
+	"gokit-seed/internal/redis/redistest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreRefillsBuckets(t *testing.T) {
+	s := NewMemoryStore(10)
+	limit := Limit{Rate: 100, Burst: 2}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if result, _ := s.Take(ctx, "a", limit); !result.Allowed || result.Remaining != 1-i {
+			t.Fatalf("take %d: %+v", i, result)
+		}
+	}
+
+	result, _ := s.Take(ctx, "a", limit)
+
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 10*time.Millisecond {
+		t.Fatalf("empty bucket: %+v", result)
+	}
+
+	if result, _ := s.Take(ctx, "b", limit); !result.Allowed {
+		t.Fatal("buckets shared between keys")
+	}
+
+	time.Sleep(result.RetryAfter)
+
+	if result, _ := s.Take(ctx, "a", limit); !result.Allowed {
+		t.Fatal("bucket not refilled")
+	}
+}
+
+func TestRedisStoreLoadsTheScriptOnce(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		commands []string
+		loaded   bool
+	)
+
+	server := redistest.NewServer(t, func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		commands = append(commands, args[0])
+
+		switch {
+		case args[0] == "EVALSHA" && args[1] == tokenBucketSha && loaded:
+		case args[0] == "EVALSHA":
+			return redistest.Error("NOSCRIPT No matching script. Please use EVAL.")
+		case args[0] == "EVAL" && args[1] == tokenBucketScript:
+			loaded = true
+		default:
+			return redistest.Error("ERR unexpected command")
+		}
+
+		if args[3] != "ratelimit:route:ip:1.2.3.4" || args[4] != "10" || args[5] != "20" {
+			return redistest.Error("ERR unexpected arguments")
+		}
+
+		return redistest.Array(redistest.Int(1), redistest.Bulk("18.5"))
+	})
+
+	client, err := redis.NewClient(server.Url(), 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := NewRedisStore(client, "ratelimit:")
+
+	for i := 0; i < 2; i++ {
+		result, err := s.Take(context.Background(), "route:ip:1.2.3.4", Limit{Rate: 10, Burst: 20})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.Allowed || result.Remaining != 18 {
+			t.Fatalf("result %+v", result)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if got := strings.Join(commands, ","); got != "EVALSHA,EVAL,EVALSHA" {
+		t.Fatalf("commands %s", got)
+	}
+}
+
+func TestCallerKeysTrustVerifiedRequestsOnly(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(auth.HeaderApiKey, "forged")
+	r.Header.Set("Authorization", "Bearer forged")
+
+	if key := ByPrincipal(r); key != "" {
+		t.Errorf("unverified request accounted to %s", key)
+	}
+
+	if key := ByApiKey(r); key != "" {
+		t.Errorf("unverified api key accounted to %s", key)
+	}
+
+	user := r.WithContext(auth.ContextWithPrincipal(r.Context(), &auth.Principal{Subject: "alice"}))
+
+	if key := ByPrincipal(user); key != "sub:alice" {
+		t.Errorf("authenticated key %s", key)
+	}
+
+	if key := ByApiKey(user); key != "" {
+		t.Errorf("user accounted to api key %s", key)
+	}
+
+	service := r.WithContext(auth.ContextWithPrincipal(r.Context(), &auth.Principal{Subject: "service:k1", Roles: []string{"service"}}))
+
+	if key := ByApiKey(service); key != "sub:service:k1" {
+		t.Errorf("service key %s", key)
+	}
+}
+
+func TestForgedCredentialsShareTheClientIpBucket(t *testing.T) {
+	l := &Limiter{store: NewMemoryStore(10), caller: ByPrincipal, ipFactor: 3, overrides: map[string]Limit{}}
+	limit := Limit{Rate: 0.001, Burst: 1}
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+	// Authentication stands in for auth.Verifier.Authenticate.
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subject := r.Header.Get("X-Subject"); subject != "" {
+				r = r.WithContext(auth.ContextWithPrincipal(r.Context(), &auth.Principal{Subject: subject}))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	h := l.Route("/route", limit)(authenticate(l.Authenticated("/route", limit)(ok)))
+
+	serve := func(token, subject string) int {
+		r := httptest.NewRequest(http.MethodGet, "/route", nil)
+		r.RemoteAddr = "1.2.3.4:5678"
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		if subject != "" {
+			r.Header.Set("X-Subject", subject)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Verified callers get their own buckets.
+	if serve("a", "alice") != http.StatusOK || serve("a", "alice") != http.StatusTooManyRequests {
+		t.Fatal("caller limit not applied")
+	}
+
+	// A new forged token each time does not get a new bucket: the client IP
+	// allows three times the route limit, two of which alice spent.
+	if code := serve("forged-1", ""); code != http.StatusOK {
+		t.Fatalf("first unverified request got %d", code)
+	}
+
+	if code := serve("forged-2", ""); code != http.StatusTooManyRequests {
+		t.Fatalf("second unverified request got %d", code)
+	}
+}
+
+func TestRouteRespondsTooManyRequests(t *testing.T) {
+	l := &Limiter{store: NewMemoryStore(10), overrides: map[string]Limit{}}
+	h := l.Route("/route", Limit{Rate: 0.001, Burst: 1})(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	codes := []int{}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/route", nil))
+		codes = append(codes, w.Code)
+
+		if w.Header().Get("RateLimit-Limit") != "1" {
+			t.Errorf("RateLimit-Limit %q", w.Header().Get("RateLimit-Limit"))
+		}
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("codes %v", codes)
+	}
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gokit-seed/internal/redis"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is the time until the bucket is full again.
+	ResetAfter time.Duration
+	// RetryAfter is the time until the next token, zero when allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets, keyed by client and route.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	result := Result{
+		Allowed:    allowed,
+		Limit:      limit.Burst,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore keeps buckets in process, evicting the least recently used
+// bucket once capacity is reached.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	buckets  map[string]*list.Element
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		order:    list.New(),
+		buckets:  map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	element, ok := s.buckets[key]
+
+	if ok {
+		s.order.MoveToFront(element)
+	} else {
+		if s.order.Len() >= s.capacity {
+			oldest := s.order.Back()
+			s.order.Remove(oldest)
+			delete(s.buckets, oldest.Value.(*bucket).key)
+		}
+
+		element = s.order.PushFront(&bucket{key: key, tokens: float64(limit.Burst), last: now})
+		s.buckets[key] = element
+	}
+
+	b := element.Value.(*bucket)
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return newResult(allowed, b.tokens, limit), nil
+}
+
+// tokenBucketScript refills and takes from a bucket atomically, using the
+// server clock so that every instance shares the same time source.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`
+
+var tokenBucketSha = func() string {
+	sum := sha1.Sum([]byte(tokenBucketScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisStore shares buckets between instances through a Redis compatible
+// server.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	args := []any{1, s.prefix + key, limit.Rate, limit.Burst}
+	reply, err := s.client.Do(ctx, append([]any{"EVALSHA", tokenBucketSha}, args...)...)
+
+	// The script cache of the server is empty after a restart or a failover,
+	// EVAL loads the script again.
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		reply, err = s.client.Do(ctx, append([]any{"EVAL", tokenBucketScript}, args...)...)
+	}
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]any)
+
+	if !ok || len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+
+	allowed, _ := values[0].(int64)
+	raw, _ := values[1].([]byte)
+	tokens, err := strconv.ParseFloat(string(raw), 64)
+
+	if err != nil {
+		return Result{}, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+
+	return newResult(allowed == 1, tokens, limit), nil
+}
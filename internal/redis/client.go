@@ -0,0 +1,314 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"gokit-seed/internal/common"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// Error is an error reply sent by the server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrPoolTimeout is returned when no connection frees up in time.
+var ErrPoolTimeout = errors.New("redis: timed out waiting for a connection")
+
+// Client is a minimal client for the Redis protocol (RESP2), enough for
+// scripts and simple commands. It works with Redis, Valkey, KeyDB or any local
+// stand-in speaking the same protocol. It opens up to poolSize connections,
+// other commands wait for one until their context is done, or dialTimeout
+// without a deadline.
+type Client struct {
+	addr        string
+	password    string
+	db          int
+	dialTimeout time.Duration
+	pool        chan *conn
+	// slots holds a token per open connection, idle or in use.
+	slots chan struct{}
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewClient parses a redis://[:password@]host:port[/db] url.
+func NewClient(rawUrl string, poolSize int) (*Client, error) {
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+
+	c := &Client{
+		addr:        u.Host,
+		dialTimeout: 5 * time.Second,
+		pool:        make(chan *conn, max(poolSize, 1)),
+		slots:       make(chan struct{}, max(poolSize, 1)),
+	}
+
+	if password, ok := u.User.Password(); ok {
+		c.password = password
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+
+	return c, nil
+}
+
+// NewClientFromEnv returns nil when REDIS_URL is not set.
+func NewClientFromEnv(lc fx.Lifecycle) (*Client, error) {
+	rawUrl := common.GetEnv("REDIS_URL")
+
+	if rawUrl == nil {
+		return nil, nil
+	}
+
+	client, err := NewClient(*rawUrl, 16)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.StopHook(client.Close))
+	return client, nil
+}
+
+// Do sends a command and returns its reply: string for simple strings, int64
+// for integers, []byte or nil for bulk strings and []any for arrays. Error
+// replies are returned as an Error.
+func (c *Client) Do(ctx context.Context, args ...any) (any, error) {
+	cn, err := c.get(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(ctx, args)
+
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		c.discard(cn)
+		return nil, err
+	}
+
+	c.put(cn)
+	return reply, err
+}
+
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.pool:
+			c.discard(cn)
+		default:
+			return nil
+		}
+	}
+}
+
+// get returns an idle connection, or dials one while under poolSize, or else
+// waits for one to be put back.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+
+	timer := time.NewTimer(c.dialTimeout)
+	defer timer.Stop()
+
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, ErrPoolTimeout
+	}
+
+	cn, err := c.dial(ctx)
+
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+
+	return cn, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := net.Dialer{Timeout: c.dialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{Conn: nc, reader: bufio.NewReader(nc)}
+
+	if c.password != "" {
+		if _, err := cn.do(ctx, []any{"AUTH", c.password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	if c.db != 0 {
+		if _, err := cn.do(ctx, []any{"SELECT", c.db}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// put never blocks, the pool has room for every open connection.
+func (c *Client) put(cn *conn) {
+	c.pool <- cn
+}
+
+func (c *Client) discard(cn *conn) {
+	cn.Close()
+	<-c.slots
+}
+
+func (cn *conn) do(ctx context.Context, args []any) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	cn.SetDeadline(deadline)
+
+	var buf []byte
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+
+	for _, arg := range args {
+		var s string
+
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			s = fmt.Sprint(v)
+		}
+
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(s)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, s...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	if _, err := cn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return cn.readReply()
+}
+
+func (cn *conn) readReply() (any, error) {
+	line, err := cn.reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, size+2)
+
+		if _, err := io.ReadFull(cn.reader, data); err != nil {
+			return nil, err
+		}
+
+		return data[:size], nil
+	case '*':
+		size, err := strconv.Atoi(payload)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size < 0 {
+			return nil, nil
+		}
+
+		items := make([]any, size)
+
+		for i := range items {
+			// Error replies nested in arrays are returned as values.
+			item, err := cn.readReply()
+
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+
+			items[i] = item
+		}
+
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unknown redis reply type %q", line[0])
+}
@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"gokit-seed/internal/redis/redistest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, poolSize int, handler redistest.Handler) (*Client, *redistest.Server) {
+	t.Helper()
+
+	server := redistest.NewServer(t, handler)
+	client, err := NewClient(server.Url(), poolSize)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { client.Close() })
+
+	return client, server
+}
+
+func TestDoDecodesReplies(t *testing.T) {
+	var received [][]string
+	var mu sync.Mutex
+
+	client, _ := newTestClient(t, 1, func(args []string) string {
+		mu.Lock()
+		received = append(received, args)
+		mu.Unlock()
+
+		switch args[0] {
+		case "PING":
+			return redistest.Simple("PONG")
+		case "INCR":
+			return redistest.Int(42)
+		case "GET":
+			if args[1] == "missing" {
+				return redistest.Nil
+			}
+			return redistest.Bulk("value\r\nwith a line break")
+		case "EVAL":
+			return redistest.Array(redistest.Int(1), redistest.Bulk("2.5"), redistest.Error("ERR nested"))
+		}
+
+		return redistest.Error("ERR unknown command")
+	})
+
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		args []any
+		want any
+	}{
+		{[]any{"PING"}, "PONG"},
+		{[]any{"INCR", "counter"}, int64(42)},
+		{[]any{"GET", "key"}, []byte("value\r\nwith a line break")},
+		{[]any{"GET", "missing"}, nil},
+	} {
+		reply, err := client.Do(ctx, tc.args...)
+
+		if err != nil {
+			t.Fatalf("%v: %v", tc.args, err)
+		}
+
+		if got, ok := reply.([]byte); ok {
+			if string(got) != string(tc.want.([]byte)) {
+				t.Errorf("%v = %q", tc.args, got)
+			}
+		} else if reply != tc.want {
+			t.Errorf("%v = %#v, want %#v", tc.args, reply, tc.want)
+		}
+	}
+
+	reply, err := client.Do(ctx, "EVAL", "return 1", 0, 1.5)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := reply.([]any)
+
+	if items[0] != int64(1) || string(items[1].([]byte)) != "2.5" || items[2] != Error("ERR nested") {
+		t.Errorf("array reply %#v", items)
+	}
+
+	var replyErr Error
+	if _, err := client.Do(ctx, "FLUSHALL"); !errors.As(err, &replyErr) || replyErr != "ERR unknown command" {
+		t.Errorf("error reply got %v", err)
+	}
+
+	// Arguments are sent as bulk strings.
+	mu.Lock()
+	defer mu.Unlock()
+
+	if last := received[len(received)-1]; !slices.Equal(last, []string{"FLUSHALL"}) {
+		t.Errorf("received %q", last)
+	}
+
+	if eval := received[4]; !slices.Equal(eval, []string{"EVAL", "return 1", "0", "1.5"}) {
+		t.Errorf("received %q", eval)
+	}
+}
+
+func TestClientAuthenticatesAndSelects(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+
+	server := redistest.NewServer(t, func(args []string) string {
+		mu.Lock()
+		received = append(received, args[0])
+		mu.Unlock()
+
+		if args[0] == "AUTH" && args[1] != "secret" {
+			return redistest.Error("WRONGPASS invalid password")
+		}
+
+		return redistest.Simple("OK")
+	})
+
+	client, err := NewClient("redis://:secret@"+server.Addr+"/2", 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Do(context.Background(), "PING")
+	client.Do(context.Background(), "PING")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// A single connection, reused.
+	if !slices.Equal(received, []string{"AUTH", "SELECT", "PING", "PING"}) {
+		t.Fatalf("received %v", received)
+	}
+}
+
+func TestClientBoundsConnections(t *testing.T) {
+	release := make(chan struct{})
+
+	client, server := newTestClient(t, 2, func(args []string) string {
+		if args[0] == "BLPOP" {
+			<-release
+		}
+
+		return redistest.Simple("OK")
+	})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			client.Do(context.Background(), "BLPOP", "queue", 0)
+		}()
+	}
+
+	// Waiters give up with their context.
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Do(ctx, "PING"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("call over the pool size got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if dials := server.Dials.Load(); dials != 2 {
+		t.Fatalf("%d connections dialed, want 2", dials)
+	}
+}
+
+func TestClientDiscardsBrokenConnections(t *testing.T) {
+	client, server := newTestClient(t, 1, func(args []string) string {
+		if args[0] == "BROKEN" {
+			return "?unknown\r\n"
+		}
+
+		return redistest.Simple("OK")
+	})
+
+	ctx := context.Background()
+
+	if _, err := client.Do(ctx, "BROKEN"); err == nil {
+		t.Fatal("malformed reply accepted")
+	}
+
+	// The slot of the broken connection is released.
+	if _, err := client.Do(ctx, "PING"); err != nil {
+		t.Fatal(err)
+	}
+
+	if dials := server.Dials.Load(); dials != 2 {
+		t.Fatalf("%d connections dialed, want 2", dials)
+	}
+}
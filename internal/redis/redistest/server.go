@@ -0,0 +1,180 @@
+// Package redistest provides a stub server speaking the Redis protocol, for
+// tests of its clients.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Handler answers a command with a raw RESP reply, see the reply helpers.
+type Handler func(args []string) string
+
+// Server runs handler for every command received. Commands of a connection
+// are answered in order, connections concurrently.
+type Server struct {
+	Addr     string
+	listener net.Listener
+	handler  Handler
+	done     chan struct{}
+	wg       sync.WaitGroup
+	// Dials counts the connections accepted.
+	Dials atomic.Int64
+}
+
+// NewServer listens on a local port until the test ends.
+func NewServer(t testing.TB, handler Handler) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Addr: listener.Addr().String(), listener: listener, handler: handler, done: make(chan struct{})}
+	s.wg.Add(1)
+
+	go s.serve()
+
+	t.Cleanup(func() {
+		close(s.done)
+		listener.Close()
+		s.wg.Wait()
+	})
+
+	return s
+}
+
+// Url is the redis:// URL of the server.
+func (s *Server) Url() string {
+	return "redis://" + s.Addr
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	var conns sync.WaitGroup
+	defer conns.Wait()
+
+	for {
+		conn, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		s.Dials.Add(1)
+		conns.Add(1)
+
+		go func() {
+			defer conns.Done()
+			defer conn.Close()
+
+			// Unblocks readers once the server closes.
+			go func() {
+				<-s.done
+				conn.Close()
+			}()
+
+			reader := bufio.NewReader(conn)
+
+			for {
+				args, err := readCommand(reader)
+
+				if err != nil {
+					return
+				}
+
+				if _, err := io.WriteString(conn, s.handler(args)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader, '*')
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(line)
+
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+
+	for i := range args {
+		line, err := readLine(reader, '$')
+
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(line)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size+2)
+
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(data[:size])
+	}
+
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader, prefix byte) (string, error) {
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(line) < 3 || line[0] != prefix || !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("malformed command line %q", line)
+	}
+
+	return line[1 : len(line)-2], nil
+}
+
+func Simple(s string) string {
+	return "+" + s + "\r\n"
+}
+
+func Error(s string) string {
+	return "-" + s + "\r\n"
+}
+
+func Int(n int64) string {
+	return ":" + strconv.FormatInt(n, 10) + "\r\n"
+}
+
+func Bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+// Nil is the null bulk string, a missing value.
+const Nil = "$-1\r\n"
+
+// Array concatenates replies into an array reply.
+func Array(replies ...string) string {
+	return "*" + strconv.Itoa(len(replies)) + "\r\n" + strings.Join(replies, "")
+}
@@ -0,0 +1,112 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// BulkheadError is returned when the bulkhead of an upstream is saturated.
+// The call was not sent.
+type BulkheadError struct {
+	Upstream string
+	// Timeout is set when the call waited in the queue, rather than finding
+	// it full.
+	Timeout bool
+}
+
+func (e *BulkheadError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("bulkhead of %s: timed out waiting for a slot", e.Upstream)
+	}
+
+	return fmt.Sprintf("bulkhead of %s: queue full", e.Upstream)
+}
+
+// StatusCode makes kithttp.DefaultErrorEncoder respond 503.
+func (e *BulkheadError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+type bulkhead struct {
+	attrs    attribute.Set
+	slots    chan struct{}
+	queued   atomic.Int64
+	maxQueue int64
+}
+
+// Bulkhead bounds the calls in progress to upstream to cfg.MaxConcurrent,
+// with up to cfg.MaxQueue more waiting cfg.QueueTimeout for a slot. Other
+// calls fail with a *BulkheadError. It is disabled when MaxConcurrent is zero.
+// The returned middleware shares its slots between the endpoints it wraps, so
+// wrap each attempt, the endpoint of every instance, to bound upstream calls.
+func Bulkhead(upstream string, cfg BulkheadConfig) endpoint.Middleware {
+	if cfg.MaxConcurrent <= 0 {
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return next
+		}
+	}
+
+	registerOnce.Do(registerMetrics)
+
+	b := &bulkhead{
+		attrs:    attribute.NewSet(attribute.String("upstream", upstream)),
+		slots:    make(chan struct{}, cfg.MaxConcurrent),
+		maxQueue: int64(cfg.MaxQueue),
+	}
+	bulkheads.Store(upstream, b)
+
+	timeout := time.Duration(cfg.QueueTimeout)
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err := b.acquire(ctx, upstream, timeout); err != nil {
+				return nil, err
+			}
+			defer func() { <-b.slots }()
+
+			return next(ctx, request)
+		}
+	}
+}
+
+func (b *bulkhead) acquire(ctx context.Context, upstream string, timeout time.Duration) error {
+	select {
+	case b.slots <- struct{}{}:
+		b.record(ctx, "admitted")
+		return nil
+	default:
+	}
+
+	if b.queued.Add(1) > b.maxQueue {
+		b.queued.Add(-1)
+		b.record(ctx, "rejected")
+		return &BulkheadError{Upstream: upstream}
+	}
+	defer b.queued.Add(-1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		b.record(ctx, "queued")
+		return nil
+	case <-timer.C:
+		b.record(ctx, "timeout")
+		return &BulkheadError{Upstream: upstream, Timeout: true}
+	case <-ctx.Done():
+		b.record(ctx, "canceled")
+		return ctx.Err()
+	}
+}
+
+func (b *bulkhead) record(ctx context.Context, outcome string) {
+	bulkheadCalls.Add(ctx, 1, metric.WithAttributeSet(b.attrs), metric.WithAttributes(attribute.String("outcome", outcome)))
+}
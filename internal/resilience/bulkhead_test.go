@@ -0,0 +1,98 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+func TestBulkheadSharesSlotsBetweenEndpoints(t *testing.T) {
+	middleware := Bulkhead("test", BulkheadConfig{MaxConcurrent: 1})
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	blocked := middleware(func(context.Context, interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	other := middleware(endpoint.Nop)
+
+	go blocked(context.Background(), nil)
+	<-started
+
+	var bulkheadErr *BulkheadError
+
+	if _, err := other(context.Background(), nil); !errors.As(err, &bulkheadErr) {
+		t.Fatalf("call on another endpoint got %v, want a *BulkheadError", err)
+	}
+
+	close(release)
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if _, err := other(context.Background(), nil); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("slot not released")
+		}
+	}
+}
+
+func TestBulkheadQueueAndCancellation(t *testing.T) {
+	middleware := Bulkhead("queue", BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: Duration(time.Second)})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	defer close(release)
+
+	go middleware(func(context.Context, interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})(context.Background(), nil)
+	<-started
+
+	e := middleware(endpoint.Nop)
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error)
+
+	go func() {
+		_, err := e(ctx, nil)
+		queued <- err
+	}()
+
+	// Wait for the call to queue, then find the queue full.
+	b, _ := bulkheads.Load("queue")
+
+	for deadline := time.Now().Add(time.Second); b.(*bulkhead).queued.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("call not queued")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	var bulkheadErr *BulkheadError
+
+	if _, err := e(context.Background(), nil); !errors.As(err, &bulkheadErr) || bulkheadErr.Timeout {
+		t.Fatalf("call with the queue full got %v", err)
+	}
+
+	cancel()
+
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled wait got %v", err)
+	}
+}
+
+func TestBulkheadDisabled(t *testing.T) {
+	e := Bulkhead("test", BulkheadConfig{})(endpoint.Nop)
+
+	if _, err := e(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,187 @@
+package resilience
+
+import (
+	"encoding/json"
+	"fmt"
+	"gokit-seed/internal/common"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration read from JSON strings such as "250ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+type BreakerConfig struct {
+	// MaxRequests is the number of probes let through while half-open.
+	MaxRequests uint32 `json:"max_requests"`
+	// Interval clears the closed state counts periodically, never when zero.
+	Interval Duration `json:"interval"`
+	// Timeout is how long the breaker stays open before probing.
+	Timeout Duration `json:"timeout"`
+	// The breaker trips after ConsecutiveFailures failures in a row, or when
+	// the failure ratio reaches FailureRatio over at least MinRequests.
+	ConsecutiveFailures uint32  `json:"consecutive_failures"`
+	FailureRatio        float64 `json:"failure_ratio"`
+	MinRequests         uint32  `json:"min_requests"`
+}
+
+type LimiterConfig struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// Delay makes callers wait for a token instead of failing fast.
+	Delay bool `json:"delay"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int `json:"max_attempts"`
+	// Timeout bounds all attempts together, AttemptTimeout each attempt.
+	Timeout        Duration `json:"timeout"`
+	AttemptTimeout Duration `json:"attempt_timeout"`
+	Backoff        Duration `json:"backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	// Jitter randomizes each backoff by up to this fraction.
+	Jitter float64 `json:"jitter"`
+	// Idempotent allows retrying requests that may have reached the upstream.
+	Idempotent bool `json:"idempotent"`
+}
+
+type EjectionConfig struct {
+	// ConsecutiveFailures ejects an instance from load balancing after that
+	// many failures in a row, never when zero.
+	ConsecutiveFailures uint32 `json:"consecutive_failures"`
+	// Duration is how long an instance stays ejected.
+	Duration Duration `json:"duration"`
+	// MaxPercent caps the share of instances ejected at once.
+	MaxPercent int `json:"max_percent"`
+}
+
+type HedgeConfig struct {
+	// Percentile of recent latencies, such as 0.95, after which a second
+	// request is sent to another instance. Hedging is disabled when zero, or
+	// when the retry policy is not idempotent.
+	Percentile float64 `json:"percentile"`
+	// MinDelay and MaxDelay bound the hedging delay, MaxDelay is also used
+	// until enough latencies were observed.
+	MinDelay Duration `json:"min_delay"`
+	MaxDelay Duration `json:"max_delay"`
+	// Window is the number of recent latencies the percentile is taken from.
+	Window int `json:"window"`
+}
+
+type BulkheadConfig struct {
+	// MaxConcurrent bounds the calls in progress to the upstream, unbounded
+	// when zero.
+	MaxConcurrent int `json:"max_concurrent"`
+	// MaxQueue calls wait up to QueueTimeout for a slot, others are rejected.
+	MaxQueue     int      `json:"max_queue"`
+	QueueTimeout Duration `json:"queue_timeout"`
+}
+
+type Config struct {
+	Breaker  BreakerConfig  `json:"breaker"`
+	Limiter  LimiterConfig  `json:"limiter"`
+	Retry    RetryConfig    `json:"retry"`
+	Ejection EjectionConfig `json:"ejection"`
+	Hedge    HedgeConfig    `json:"hedge"`
+	Bulkhead BulkheadConfig `json:"bulkhead"`
+}
+
+// DefaultConfig keeps the historical hardcoded breaker, limiter and single
+// attempt of the proxy, the breaker tripping on more than 5 failures in a row.
+// It also enables what the proxy did not have: ejection of failing instances
+// and a bulkhead. Hedging stays off until a percentile is configured.
+func DefaultConfig() Config {
+	return Config{
+		Breaker: BreakerConfig{
+			MaxRequests:         1,
+			Timeout:             Duration(60 * time.Second),
+			ConsecutiveFailures: 6,
+		},
+		Limiter: LimiterConfig{
+			Rate:  0.2,
+			Burst: 1,
+		},
+		Retry: RetryConfig{
+			MaxAttempts: 1,
+			Timeout:     Duration(5 * time.Second),
+		},
+		Ejection: EjectionConfig{
+			ConsecutiveFailures: 5,
+			Duration:            Duration(30 * time.Second),
+			MaxPercent:          50,
+		},
+		Hedge: HedgeConfig{
+			Window: 100,
+		},
+		Bulkhead: BulkheadConfig{
+			MaxConcurrent: 100,
+			MaxQueue:      100,
+			QueueTimeout:  Duration(time.Second),
+		},
+	}
+}
+
+// Configs holds the resilience policy of each upstream by name.
+type Configs map[string]Config
+
+// LoadConfigs reads per upstream policies from the JSON file at
+// RESILIENCE_CONFIG, shaped as {"upstream": {"breaker": {...}, ...}}. Fields
+// left out fall back to DefaultConfig.
+func LoadConfigs() (Configs, error) {
+	path := common.GetEnv("RESILIENCE_CONFIG")
+
+	if path == nil {
+		return Configs{}, nil
+	}
+
+	data, err := os.ReadFile(*path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", *path, err)
+	}
+
+	configs := Configs{}
+
+	for upstream, message := range raw {
+		config := DefaultConfig()
+
+		if err := json.Unmarshal(message, &config); err != nil {
+			return nil, fmt.Errorf("parsing %s: upstream %q: %w", *path, upstream, err)
+		}
+
+		configs[upstream] = config
+	}
+
+	return configs, nil
+}
+
+func (c Configs) For(upstream string) Config {
+	if config, ok := c[upstream]; ok {
+		return config
+	}
+
+	return DefaultConfig()
+}
@@ -0,0 +1,168 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"gokit-seed/internal/common"
+	"gokit-seed/internal/discovery"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+// Ejector is an sd.Instancer hiding the instances of source that keep failing,
+// as reported by its Middleware, until the ejection expires.
+type Ejector struct {
+	*discovery.Cache
+	upstream string
+	source   sd.Instancer
+	cfg      EjectionConfig
+	logger   *zap.Logger
+	events   chan sd.Event
+
+	mu        sync.Mutex
+	instances []string
+	failures  map[string]uint32
+	ejected   map[string]bool
+}
+
+func NewEjector(upstream string, source sd.Instancer, cfg EjectionConfig, logger *zap.Logger) *Ejector {
+	registerOnce.Do(registerMetrics)
+
+	e := &Ejector{
+		Cache:    discovery.NewCache(),
+		upstream: upstream,
+		source:   source,
+		cfg:      cfg,
+		logger:   logger,
+		events:   make(chan sd.Event),
+		failures: map[string]uint32{},
+		ejected:  map[string]bool{},
+	}
+
+	go func() {
+		for event := range e.events {
+			e.mu.Lock()
+
+			if event.Err != nil {
+				e.Update(event)
+			} else {
+				e.instances = event.Instances
+				e.publish()
+			}
+
+			e.mu.Unlock()
+		}
+	}()
+
+	source.Register(e.events)
+	return e
+}
+
+// Stop stops listening to the source, it does not stop the source itself.
+func (e *Ejector) Stop() {
+	e.source.Deregister(e.events)
+	close(e.events)
+}
+
+// Middleware reports the outcome of calls to instance. Wrap the per instance
+// policy with it, so that attempt timeouts count as failures while callers
+// giving up do not.
+func (e *Ejector) Middleware(instance string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if e.cfg.ConsecutiveFailures == 0 {
+			return next
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := next(ctx, request)
+
+			if ctx.Err() == nil && !refused(err) {
+				e.report(instance, err != nil && isInstanceFailure(err))
+			}
+
+			return response, err
+		}
+	}
+}
+
+func (e *Ejector) report(instance string, failed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !failed {
+		delete(e.failures, instance)
+		return
+	}
+
+	e.failures[instance]++
+
+	if e.failures[instance] < e.cfg.ConsecutiveFailures || e.ejected[instance] {
+		return
+	}
+
+	if (len(e.ejected)+1)*100 > len(e.instances)*e.cfg.MaxPercent {
+		return
+	}
+
+	e.ejected[instance] = true
+	delete(e.failures, instance)
+	e.publish()
+
+	e.logger.Warn(
+		"upstream instance ejected",
+		zap.String("upstream", e.upstream),
+		zap.String("instance", instance),
+		zap.Duration("duration", time.Duration(e.cfg.Duration)),
+	)
+	ejections.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("upstream", e.upstream),
+		attribute.String("instance", instance),
+	))
+
+	time.AfterFunc(time.Duration(e.cfg.Duration), func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		delete(e.ejected, instance)
+		e.publish()
+	})
+}
+
+// publish broadcasts the instances not ejected, e.mu must be held.
+func (e *Ejector) publish() {
+	instances := make([]string, 0, len(e.instances))
+
+	for _, instance := range e.instances {
+		if !e.ejected[instance] {
+			instances = append(instances, instance)
+		}
+	}
+
+	// Forget ejections of instances the source no longer lists.
+	for instance := range e.ejected {
+		if !slices.Contains(e.instances, instance) {
+			delete(e.ejected, instance)
+		}
+	}
+
+	e.Update(sd.Event{Instances: instances})
+}
+
+// isInstanceFailure reports errors hinting the instance itself is unhealthy,
+// as opposed to the request being refused.
+func isInstanceFailure(err error) bool {
+	var statusErr *common.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= http.StatusInternalServerError
+	}
+
+	return !errors.Is(err, context.Canceled)
+}
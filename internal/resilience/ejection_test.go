@@ -0,0 +1,100 @@
+package resilience
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+func newTestEjector(t *testing.T) *Ejector {
+	t.Helper()
+
+	e := NewEjector("test", sd.FixedInstancer{"a", "b"}, EjectionConfig{
+		ConsecutiveFailures: 2,
+		Duration:            Duration(time.Minute),
+		MaxPercent:          50,
+	}, zap.NewNop())
+	t.Cleanup(e.Stop)
+
+	return e
+}
+
+func waitInstances(t *testing.T, e *Ejector, want ...string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if got := e.State().Instances; slices.Equal(got, want) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("instances %v, want %v", e.State().Instances, want)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEjectorCountsAttemptTimeouts(t *testing.T) {
+	e := newTestEjector(t)
+	waitInstances(t, e, "a", "b")
+
+	slow := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	call := endpoint.Chain(e.Middleware("a"), attemptTimeout(time.Millisecond))(slow)
+
+	for i := 0; i < 2; i++ {
+		if _, err := call(context.Background(), nil); err != ErrAttemptTimeout {
+			t.Fatalf("slow call got %v", err)
+		}
+	}
+
+	waitInstances(t, e, "b")
+}
+
+func TestEjectorIgnoresCallersGivingUpAndRefusals(t *testing.T) {
+	e := newTestEjector(t)
+	waitInstances(t, e, "a", "b")
+
+	slow := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	call := endpoint.Chain(e.Middleware("a"), attemptTimeout(time.Minute))(slow)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		call(ctx, nil)
+		cancel()
+	}
+
+	// Limited calls were not sent, they must not count as failures either.
+	policy, closer := Instance("test", "a", Config{
+		Breaker: BreakerConfig{ConsecutiveFailures: 100},
+		Limiter: LimiterConfig{Rate: 0.001, Burst: 1},
+	}, zap.NewNop())
+	defer closer.Close()
+
+	limited := endpoint.Chain(e.Middleware("a"), policy)(endpoint.Nop)
+
+	for i := 0; i < 3; i++ {
+		limited(context.Background(), nil)
+	}
+
+	e.mu.Lock()
+	failures := e.failures["a"]
+	e.mu.Unlock()
+
+	if failures != 0 {
+		t.Fatalf("%d failures counted", failures)
+	}
+
+	waitInstances(t, e, "a", "b")
+}
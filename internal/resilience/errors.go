@@ -0,0 +1,73 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"gokit-seed/internal/common"
+	"net"
+	"net/http"
+
+	kitratelimit "github.com/go-kit/kit/ratelimit"
+	"github.com/sony/gobreaker"
+)
+
+// ErrAttemptTimeout is returned when a single attempt exceeds its timeout.
+var ErrAttemptTimeout = errors.New("upstream attempt timed out")
+
+// refused reports calls the per instance policy did not send, which say
+// nothing new about the instance.
+func refused(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) ||
+		errors.Is(err, gobreaker.ErrTooManyRequests) ||
+		errors.Is(err, kitratelimit.ErrLimited)
+}
+
+// Refused reports calls the policy of an upstream did not send: refused by
+// the rate limiter or circuit breaker of the instance, or by the bulkhead.
+func Refused(err error) bool {
+	var bulkheadErr *BulkheadError
+	return refused(err) || errors.As(err, &bulkheadErr)
+}
+
+// notSent reports errors proving the request never reached the upstream, which
+// are safe to retry even for non idempotent calls.
+func notSent(err error) bool {
+	if refused(err) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// IsRetriable reports whether a failed call may succeed on another attempt.
+func IsRetriable(err error, idempotent bool) bool {
+	if notSent(err) {
+		return true
+	}
+
+	if !idempotent {
+		return false
+	}
+
+	var statusErr *common.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, ErrAttemptTimeout) {
+		return true
+	}
+
+	// The caller gave up, retrying would be pointless.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
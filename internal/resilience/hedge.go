@@ -0,0 +1,177 @@
+package resilience
+
+import (
+	"context"
+	"gokit-seed/internal/balancer"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd/lb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// minSamples is the number of latencies needed before the percentile is
+// trusted, MaxDelay is used until then.
+const minSamples = 20
+
+type hedger struct {
+	upstream string
+	cfg      HedgeConfig
+	balancer lb.Balancer
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+// Hedge returns a balancer whose endpoints send a second request to another
+// instance when the first one is slower than the configured percentile of
+// recent latencies, keeping the first successful response and canceling the
+// other. The instances are picked by next, which must pick them when its
+// endpoints are called for the second one to differ, as the balancers of the
+// balancer package do. Calls are only hedged when cfg.Retry.Idempotent is set.
+func Hedge(upstream string, cfg Config, next lb.Balancer) lb.Balancer {
+	if cfg.Hedge.Percentile <= 0 || !cfg.Retry.Idempotent {
+		return next
+	}
+
+	registerOnce.Do(registerMetrics)
+
+	return &hedger{
+		upstream:  upstream,
+		cfg:       cfg.Hedge,
+		balancer:  next,
+		latencies: make([]time.Duration, 0, max(cfg.Hedge.Window, minSamples)),
+	}
+}
+
+type hedgeResult struct {
+	response interface{}
+	err      error
+	hedge    bool
+}
+
+func (h *hedger) Endpoint() (endpoint.Endpoint, error) {
+	primary, err := h.balancer.Endpoint()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithCancel(balancer.ContextWithAttempts(ctx))
+		defer cancel()
+
+		results := make(chan hedgeResult, 2)
+
+		call := func(e endpoint.Endpoint, hedge bool) {
+			start := time.Now()
+			response, err := e(ctx, request)
+
+			if err == nil {
+				h.observe(time.Since(start))
+			}
+
+			results <- hedgeResult{response, err, hedge}
+		}
+
+		go call(primary, false)
+
+		var timeout <-chan time.Time
+
+		if delay, ok := h.delay(); ok {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		span := trace.SpanFromContext(ctx)
+		pending, hedged := 1, false
+
+		for {
+			select {
+			case result := <-results:
+				pending--
+
+				if result.err == nil {
+					if hedged {
+						h.record(ctx, span, result.hedge)
+					}
+					return result.response, nil
+				}
+
+				if pending == 0 {
+					if hedged {
+						hedges.Add(ctx, 1, metric.WithAttributes(
+							attribute.String("upstream", h.upstream),
+							attribute.String("outcome", "failed"),
+						))
+					}
+					return nil, result.err
+				}
+			case <-timeout:
+				secondary, err := h.balancer.Endpoint()
+
+				if err != nil {
+					continue
+				}
+
+				span.AddEvent("hedge.sent")
+				pending, hedged = pending+1, true
+				go call(secondary, true)
+			}
+		}
+	}, nil
+}
+
+func (h *hedger) record(ctx context.Context, span trace.Span, hedge bool) {
+	outcome := "primary"
+	if hedge {
+		outcome = "hedge"
+	}
+
+	span.AddEvent("hedge.won", trace.WithAttributes(attribute.String("hedge.winner", outcome)))
+	hedges.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("upstream", h.upstream),
+		attribute.String("outcome", outcome),
+	))
+}
+
+func (h *hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < cap(h.latencies) {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % len(h.latencies)
+}
+
+// delay returns the configured percentile of recent latencies, bounded by
+// MinDelay and MaxDelay, or MaxDelay until there are enough samples. It
+// returns false when no hedge should be sent.
+func (h *hedger) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	latencies := slices.Clone(h.latencies)
+	h.mu.Unlock()
+
+	if len(latencies) < minSamples {
+		return time.Duration(h.cfg.MaxDelay), h.cfg.MaxDelay > 0
+	}
+
+	slices.Sort(latencies)
+	delay := latencies[min(int(float64(len(latencies))*h.cfg.Percentile), len(latencies)-1)]
+
+	if h.cfg.MaxDelay > 0 {
+		delay = min(delay, time.Duration(h.cfg.MaxDelay))
+	}
+
+	return max(delay, time.Duration(h.cfg.MinDelay)), true
+}
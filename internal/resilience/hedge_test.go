@@ -0,0 +1,108 @@
+package resilience
+
+import (
+	"context"
+	"gokit-seed/internal/balancer"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd"
+	"go.uber.org/zap"
+)
+
+// slowEndpointer serves instances "a", "b" and "c", recording the instances
+// each call reaches. Instance "a" is slow.
+func slowEndpointer(t *testing.T, mu *sync.Mutex, reached *[]string) *balancer.Endpointer {
+	t.Helper()
+
+	e := balancer.NewEndpointer("test", sd.FixedInstancer{"a", "b", "c"}, func(name string) (endpoint.Endpoint, io.Closer, error) {
+		return func(ctx context.Context, _ interface{}) (interface{}, error) {
+			mu.Lock()
+			*reached = append(*reached, name)
+			mu.Unlock()
+
+			if name == "a" {
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+
+			return name, nil
+		}, nil, nil
+	}, zap.NewNop())
+	t.Cleanup(e.Close)
+
+	for deadline := time.Now().Add(time.Second); ; {
+		if instances, _ := e.Instances(); len(instances) == 3 {
+			return e
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("instances not discovered")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func hedgeConfig(idempotent bool) Config {
+	cfg := DefaultConfig()
+	cfg.Retry.Idempotent = idempotent
+	cfg.Hedge = HedgeConfig{Percentile: 0.95, MaxDelay: Duration(20 * time.Millisecond), Window: 100}
+	return cfg
+}
+
+func TestHedgeGoesToAnotherInstance(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		reached []string
+	)
+
+	// Round robin starts with "a", which is slow.
+	hedged := Hedge("test", hedgeConfig(true), balancer.RoundRobin(slowEndpointer(t, &mu, &reached)))
+	e, err := hedged.Endpoint()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	response, err := e(context.Background(), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedged call took %s", elapsed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(reached) != 2 || reached[0] == reached[1] {
+		t.Fatalf("call reached %v, want two distinct instances", reached)
+	}
+
+	if response == "a" {
+		t.Fatal("slow primary won")
+	}
+}
+
+func TestHedgeRequiresIdempotentCalls(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		reached []string
+	)
+
+	lb := balancer.RoundRobin(slowEndpointer(t, &mu, &reached))
+
+	if _, ok := Hedge("test", hedgeConfig(false), lb).(*hedger); ok {
+		t.Fatal("non idempotent calls hedged")
+	}
+}
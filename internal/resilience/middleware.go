@@ -0,0 +1,250 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"gokit-seed/internal/balancer"
+	"io"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	kitratelimit "github.com/go-kit/kit/ratelimit"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const meterName = "gokit-seed/resilience"
+
+var (
+	breakers      sync.Map // name -> *namedBreaker
+	bulkheads     sync.Map // upstream -> *bulkhead
+	registerOnce  sync.Once
+	transitions   metric.Int64Counter
+	ejections     metric.Int64Counter
+	hedges        metric.Int64Counter
+	bulkheadCalls metric.Int64Counter
+)
+
+type namedBreaker struct {
+	*gobreaker.CircuitBreaker
+	upstream string
+	instance string
+}
+
+func registerMetrics() {
+	meter := otel.Meter(meterName)
+
+	transitions, _ = meter.Int64Counter(
+		"resilience.breaker.transitions",
+		metric.WithDescription("Circuit breaker state changes"),
+	)
+
+	ejections, _ = meter.Int64Counter(
+		"resilience.ejections",
+		metric.WithDescription("Instances ejected from load balancing"),
+	)
+
+	hedges, _ = meter.Int64Counter(
+		"resilience.hedges",
+		metric.WithDescription("Hedged calls by winning attempt: primary, hedge or failed"),
+	)
+
+	bulkheadCalls, _ = meter.Int64Counter(
+		"resilience.bulkhead.calls",
+		metric.WithDescription("Calls through upstream bulkheads by outcome: admitted, queued, rejected, timeout or canceled"),
+	)
+
+	meter.Int64ObservableGauge(
+		"resilience.bulkhead.slots",
+		metric.WithDescription("Bulkhead slots by state: limit, active and queued calls"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			bulkheads.Range(func(_, value any) bool {
+				b := value.(*bulkhead)
+				o.Observe(int64(cap(b.slots)), metric.WithAttributeSet(b.attrs), metric.WithAttributes(attribute.String("state", "limit")))
+				o.Observe(int64(len(b.slots)), metric.WithAttributeSet(b.attrs), metric.WithAttributes(attribute.String("state", "active")))
+				o.Observe(b.queued.Load(), metric.WithAttributeSet(b.attrs), metric.WithAttributes(attribute.String("state", "queued")))
+				return true
+			})
+			return nil
+		}),
+	)
+
+	meter.Int64ObservableGauge(
+		"resilience.breaker.state",
+		metric.WithDescription("Circuit breaker state: 0 closed, 1 half-open, 2 open"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			breakers.Range(func(_, value any) bool {
+				b := value.(*namedBreaker)
+				o.Observe(int64(b.State()), metric.WithAttributes(
+					attribute.String("upstream", b.upstream),
+					attribute.String("instance", b.instance),
+				))
+				return true
+			})
+			return nil
+		}),
+	)
+}
+
+// Instance returns the per instance part of a policy: rate limiter, circuit
+// breaker and attempt timeout, in that order. Close the returned io.Closer
+// when the instance goes away to stop reporting its breaker state.
+func Instance(upstream, instance string, cfg Config, logger *zap.Logger) (endpoint.Middleware, io.Closer) {
+	registerOnce.Do(registerMetrics)
+
+	breaker := &namedBreaker{
+		CircuitBreaker: gobreaker.NewCircuitBreaker(breakerSettings(upstream, instance, cfg.Breaker, logger)),
+		upstream:       upstream,
+		instance:       instance,
+	}
+	breakers.Store(breaker.Name(), breaker)
+
+	limiter := endpoint.Middleware(func(next endpoint.Endpoint) endpoint.Endpoint {
+		return next
+	})
+
+	if cfg.Limiter.Rate > 0 {
+		l := rate.NewLimiter(rate.Limit(cfg.Limiter.Rate), max(cfg.Limiter.Burst, 1))
+
+		if cfg.Limiter.Delay {
+			limiter = kitratelimit.NewDelayingLimiter(l)
+		} else {
+			limiter = kitratelimit.NewErroringLimiter(l)
+		}
+	}
+
+	closer := closerFunc(func() error {
+		breakers.Delete(breaker.Name())
+		return nil
+	})
+
+	return endpoint.Chain(
+		limiter,
+		circuitbreaker.Gobreaker(breaker.CircuitBreaker),
+		attemptTimeout(time.Duration(cfg.Retry.AttemptTimeout)),
+	), closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func breakerSettings(upstream, instance string, cfg BreakerConfig, logger *zap.Logger) gobreaker.Settings {
+	return gobreaker.Settings{
+		Name:        upstream + "/" + instance,
+		MaxRequests: cfg.MaxRequests,
+		Interval:    time.Duration(cfg.Interval),
+		Timeout:     time.Duration(cfg.Timeout),
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			if cfg.ConsecutiveFailures > 0 && counts.ConsecutiveFailures >= cfg.ConsecutiveFailures {
+				return true
+			}
+
+			return cfg.FailureRatio > 0 &&
+				counts.Requests >= max(cfg.MinRequests, 1) &&
+				float64(counts.TotalFailures)/float64(counts.Requests) >= cfg.FailureRatio
+		},
+		// Callers canceling, such as hedging, say nothing about the instance.
+		IsSuccessful: func(err error) bool {
+			return err == nil || errors.Is(err, context.Canceled)
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			logger.Warn(
+				"circuit breaker state changed",
+				zap.String("breaker", name),
+				zap.String("from", from.String()),
+				zap.String("to", to.String()),
+			)
+			transitions.Add(context.Background(), 1, metric.WithAttributes(
+				attribute.String("upstream", upstream),
+				attribute.String("instance", instance),
+				attribute.String("from", from.String()),
+				attribute.String("to", to.String()),
+			))
+		},
+	}
+}
+
+func attemptTimeout(timeout time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		if timeout <= 0 {
+			return next
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			response, err := next(attemptCtx, request)
+
+			if err != nil && ctx.Err() == nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) {
+				return nil, ErrAttemptTimeout
+			}
+
+			return response, err
+		}
+	}
+}
+
+// Retry load balances calls over next, retrying retriable errors with
+// exponential backoff and jitter on instances not tried yet, see
+// balancer.ContextWithAttempts.
+func Retry(cfg RetryConfig, next lb.Balancer) endpoint.Endpoint {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = time.Duration(DefaultConfig().Retry.Timeout)
+	}
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(balancer.ContextWithAttempts(ctx), timeout)
+		defer cancel()
+
+		retry := lb.RetryWithCallback(timeout, next, func(n int, err error) (bool, error) {
+			if n >= cfg.MaxAttempts || !IsRetriable(err, cfg.Idempotent) {
+				return false, nil
+			}
+
+			// Backing off must not outlive the caller nor the retry timeout.
+			timer := time.NewTimer(backoff(cfg, n))
+			defer timer.Stop()
+
+			select {
+			case <-timer.C:
+				return true, nil
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		})
+
+		return retry(ctx, request)
+	}
+}
+
+func backoff(cfg RetryConfig, attempt int) time.Duration {
+	if cfg.Backoff <= 0 {
+		return 0
+	}
+
+	delay := float64(cfg.Backoff) * math.Pow(2, float64(attempt-1))
+
+	if cfg.MaxBackoff > 0 {
+		delay = math.Min(delay, float64(cfg.MaxBackoff))
+	}
+
+	if cfg.Jitter > 0 {
+		delay += delay * cfg.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(delay)
+}
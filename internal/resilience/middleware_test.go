@@ -0,0 +1,98 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"gokit-seed/internal/common"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+)
+
+// failingBalancer always returns an endpoint failing with a retriable error.
+type failingBalancer struct {
+	calls chan struct{}
+}
+
+func (b failingBalancer) Endpoint() (endpoint.Endpoint, error) {
+	return func(context.Context, interface{}) (interface{}, error) {
+		b.calls <- struct{}{}
+		return nil, &common.StatusError{Code: http.StatusServiceUnavailable}
+	}, nil
+}
+
+func TestRetryBackoffStopsWithTheCaller(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, Timeout: Duration(time.Minute), Backoff: Duration(time.Minute), Idempotent: true}
+	b := failingBalancer{calls: make(chan struct{}, 3)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		_, err := Retry(cfg, b)(ctx, nil)
+		done <- err
+	}()
+
+	<-b.calls
+	cancel()
+
+	select {
+	case err := <-done:
+		var retryErr lb.RetryError
+		if errors.As(err, &retryErr) {
+			err = retryErr.Final
+		}
+
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("canceled retry got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retry kept backing off after the caller gave up")
+	}
+
+	if len(b.calls) != 0 {
+		t.Fatal("retried after the caller gave up")
+	}
+}
+
+func TestRetryBacksOffBetweenAttempts(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 3, Timeout: Duration(time.Second), Backoff: Duration(10 * time.Millisecond), Idempotent: true}
+	b := failingBalancer{calls: make(chan struct{}, 3)}
+	start := time.Now()
+
+	if _, err := Retry(cfg, b)(context.Background(), nil); err == nil {
+		t.Fatal("failing calls succeeded")
+	}
+
+	if len(b.calls) != 3 {
+		t.Fatalf("%d attempts, want 3", len(b.calls))
+	}
+
+	// 10ms then 20ms.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("attempts took %s, less than the backoff", elapsed)
+	}
+}
+
+func TestDefaultBreakerTripsOnMoreThanFiveFailures(t *testing.T) {
+	breaker := gobreaker.NewCircuitBreaker(breakerSettings("test", "a", DefaultConfig().Breaker, zap.NewNop()))
+	fail := func() (interface{}, error) { return nil, errors.New("failed") }
+
+	for i := 0; i < 5; i++ {
+		breaker.Execute(fail)
+	}
+
+	if breaker.State() != gobreaker.StateClosed {
+		t.Fatal("breaker tripped on 5 failures")
+	}
+
+	breaker.Execute(fail)
+
+	if breaker.State() != gobreaker.StateOpen {
+		t.Fatal("breaker closed after 6 failures")
+	}
+}
@@ -0,0 +1,57 @@
+package slo
+
+import "time"
+
+const (
+	resolution = time.Minute
+	// slots covers the longest window.
+	slots = int64(6 * time.Hour / resolution)
+)
+
+type counts struct {
+	good  int64
+	total int64
+}
+
+// series counts events per minute over the last slots minutes.
+type series struct {
+	slots [slots]counts
+	last  int64
+}
+
+func (s *series) add(now time.Time, good bool) {
+	slot := s.advance(now)
+	slot.total++
+
+	if good {
+		slot.good++
+	}
+}
+
+// sum returns the counts of the window ending at now.
+func (s *series) sum(now time.Time, window time.Duration) counts {
+	s.advance(now)
+
+	var sum counts
+
+	for i := int64(0); i < min(int64(window/resolution), slots); i++ {
+		slot := s.slots[(s.last-i)%slots]
+		sum.good += slot.good
+		sum.total += slot.total
+	}
+
+	return sum
+}
+
+// advance clears the slots elapsed since the last event and returns the slot
+// of now.
+func (s *series) advance(now time.Time) *counts {
+	minute := now.Unix() / int64(resolution/time.Second)
+
+	for m := max(s.last+1, minute-slots+1); m <= minute; m++ {
+		s.slots[m%slots] = counts{}
+	}
+
+	s.last = max(s.last, minute)
+	return &s.slots[minute%slots]
+}
@@ -0,0 +1,69 @@
+package slo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeriesSumsWindows(t *testing.T) {
+	var s series
+	start := time.Unix(1_700_000_000, 0).Truncate(resolution)
+
+	// Two events a minute for ten minutes, one of them good.
+	for i := 0; i < 10; i++ {
+		now := start.Add(time.Duration(i) * resolution)
+		s.add(now, true)
+		s.add(now.Add(30*time.Second), false)
+	}
+
+	now := start.Add(9*resolution + 45*time.Second)
+
+	for _, tc := range []struct {
+		window time.Duration
+		want   counts
+	}{
+		{resolution, counts{good: 1, total: 2}},
+		{5 * time.Minute, counts{good: 5, total: 10}},
+		{time.Hour, counts{good: 10, total: 20}},
+		// Windows are capped to the slots kept.
+		{24 * time.Hour, counts{good: 10, total: 20}},
+	} {
+		if got := s.sum(now, tc.window); got != tc.want {
+			t.Errorf("sum over %s = %+v, want %+v", tc.window, got, tc.want)
+		}
+	}
+
+	// Minutes without events are empty, the window slides past the events.
+	if got := s.sum(now.Add(3*resolution), 3*resolution); got != (counts{}) {
+		t.Errorf("sum after idle minutes = %+v", got)
+	}
+
+	if got := s.sum(now.Add(5*resolution), 10*resolution); got != (counts{good: 5, total: 10}) {
+		t.Errorf("sliding sum = %+v", got)
+	}
+}
+
+func TestSeriesWrapsAround(t *testing.T) {
+	var s series
+	start := time.Unix(1_700_000_000, 0).Truncate(resolution)
+
+	s.add(start, false)
+	s.add(start.Add(time.Hour), true)
+
+	// The slot of start is reused a full ring later.
+	later := start.Add(time.Duration(slots) * resolution)
+	s.add(later, true)
+
+	if got := s.sum(later, resolution); got != (counts{good: 1, total: 1}) {
+		t.Errorf("reused slot = %+v", got)
+	}
+
+	if got := s.sum(later, 6*time.Hour); got != (counts{good: 2, total: 2}) {
+		t.Errorf("sum over the ring = %+v", got)
+	}
+
+	// Gaps longer than the ring clear every slot.
+	if got := s.sum(later.Add(7*time.Hour), 6*time.Hour); got != (counts{}) {
+		t.Errorf("sum after a long gap = %+v", got)
+	}
+}
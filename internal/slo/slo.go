@@ -0,0 +1,287 @@
+package slo
+
+import (
+	"context"
+	"encoding/json"
+	"gokit-seed/internal/admin"
+	"gokit-seed/internal/common"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Objective is the share of events of a route that must be good. Requests
+// failing with a 5xx status are bad, and so are requests slower than Latency
+// when it is set.
+type Objective struct {
+	Name    string
+	Target  float64
+	Latency time.Duration
+}
+
+func Availability(target float64) Objective {
+	return Objective{Name: "availability", Target: target}
+}
+
+func Latency(threshold time.Duration, target float64) Objective {
+	return Objective{Name: "latency", Target: target, Latency: threshold}
+}
+
+type Window struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Windows are the burn rate windows, paired as in the multiwindow alerts of
+// the SRE workbook: 5m with 1h and 30m with 6h.
+var Windows = []Window{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+const (
+	StateOk       = "ok"
+	StateWarning  = "warning"
+	StateCritical = "critical"
+)
+
+var (
+	registerOnce sync.Once
+	goodEvents   metric.Int64Counter
+	totalEvents  metric.Int64Counter
+)
+
+func registerMetrics() {
+	meter := otel.Meter("gokit-seed/slo")
+
+	goodEvents, _ = meter.Int64Counter(
+		"slo.events.good",
+		metric.WithDescription("Events meeting their objective"),
+	)
+
+	totalEvents, _ = meter.Int64Counter(
+		"slo.events.total",
+		metric.WithDescription("Events accounted to an objective"),
+	)
+}
+
+type slo struct {
+	route     string
+	objective Objective
+	attrs     attribute.Set
+	mu        sync.Mutex
+	series    series
+}
+
+func (s *slo) record(ctx context.Context, status int, duration time.Duration) {
+	good := status < 500 && (s.objective.Latency == 0 || duration <= s.objective.Latency)
+
+	s.mu.Lock()
+	s.series.add(time.Now(), good)
+	s.mu.Unlock()
+
+	totalEvents.Add(ctx, 1, metric.WithAttributeSet(s.attrs))
+
+	if good {
+		goodEvents.Add(ctx, 1, metric.WithAttributeSet(s.attrs))
+	}
+}
+
+func (s *slo) windows(now time.Time) []WindowStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows := make([]WindowStatus, len(Windows))
+
+	for i, window := range Windows {
+		sum := s.series.sum(now, window.Duration)
+		windows[i] = WindowStatus{
+			Window:   window.Name,
+			Good:     sum.good,
+			Total:    sum.total,
+			BurnRate: burnRate(sum, s.objective.Target),
+		}
+	}
+
+	return windows
+}
+
+// burnRate is how many times faster than allowed by target the error budget
+// is spent.
+func burnRate(sum counts, target float64) float64 {
+	if sum.total == 0 || target >= 1 {
+		return 0
+	}
+
+	errorRatio := float64(sum.total-sum.good) / float64(sum.total)
+	return errorRatio / (1 - target)
+}
+
+type WindowStatus struct {
+	Window   string  `json:"window"`
+	Good     int64   `json:"good"`
+	Total    int64   `json:"total"`
+	BurnRate float64 `json:"burnRate"`
+}
+
+type Status struct {
+	Route     string         `json:"route"`
+	Objective string         `json:"objective"`
+	Target    float64        `json:"target"`
+	Latency   string         `json:"latency,omitempty"`
+	Windows   []WindowStatus `json:"windows"`
+	State     string         `json:"state"`
+}
+
+// Tracker computes the burn rates of the objectives declared with Route.
+type Tracker struct {
+	mu   sync.Mutex
+	slos []*slo
+}
+
+func NewTracker() (*Tracker, error) {
+	registerOnce.Do(registerMetrics)
+
+	t := &Tracker{}
+	meter := otel.Meter("gokit-seed/slo")
+
+	_, err := meter.Float64ObservableGauge(
+		"slo.burn_rate",
+		metric.WithDescription("Rate at which the error budget is spent, 1 spends it exactly over the SLO period"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			now := time.Now()
+
+			for _, s := range t.all() {
+				for _, window := range s.windows(now) {
+					o.Observe(window.BurnRate, metric.WithAttributeSet(s.attrs), metric.WithAttributes(attribute.String("slo.window", window.Window)))
+				}
+			}
+
+			return nil
+		}),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.Float64ObservableGauge(
+		"slo.target",
+		metric.WithDescription("Share of events that must be good"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			for _, s := range t.all() {
+				o.Observe(s.objective.Target, metric.WithAttributeSet(s.attrs))
+			}
+
+			return nil
+		}),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *Tracker) all() []*slo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.slos
+}
+
+// Route returns a common.HandleChain accounting requests to route against
+// objectives.
+func (t *Tracker) Route(route string, objectives ...Objective) common.HandleChain {
+	slos := make([]*slo, len(objectives))
+
+	for i, objective := range objectives {
+		slos[i] = &slo{
+			route:     route,
+			objective: objective,
+			attrs: attribute.NewSet(
+				attribute.String("http.route", route),
+				attribute.String("slo.objective", objective.Name),
+			),
+		}
+	}
+
+	t.mu.Lock()
+	t.slos = append(t.slos, slos...)
+	t.mu.Unlock()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			writer := common.NewStatusWriter(w)
+
+			next.ServeHTTP(writer, r)
+
+			duration := time.Since(start)
+
+			for _, s := range slos {
+				s.record(r.Context(), writer.Status, duration)
+			}
+		})
+	}
+}
+
+// Status reports every objective with its burn rates. The state is critical
+// when both the 5m and 1h burn rates exceed 14.4, which spends 2% of a 30 day
+// budget in an hour, and warning when both the 30m and 6h ones exceed 6.
+func (t *Tracker) Status() []Status {
+	now := time.Now()
+	var statuses []Status
+
+	for _, s := range t.all() {
+		windows := s.windows(now)
+		burn := map[string]float64{}
+
+		for _, window := range windows {
+			burn[window.Window] = window.BurnRate
+		}
+
+		state := StateOk
+
+		switch {
+		case burn["5m"] > 14.4 && burn["1h"] > 14.4:
+			state = StateCritical
+		case burn["30m"] > 6 && burn["6h"] > 6:
+			state = StateWarning
+		}
+
+		status := Status{
+			Route:     s.route,
+			Objective: s.objective.Name,
+			Target:    s.objective.Target,
+			Windows:   windows,
+			State:     state,
+		}
+
+		if s.objective.Latency > 0 {
+			status.Latency = s.objective.Latency.String()
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// NewAdminRoute serves Status as JSON on /slo of the admin listener.
+func NewAdminRoute(t *Tracker) admin.Route {
+	return admin.Route{
+		Path: "/slo",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(t.Status())
+		}),
+	}
+}
@@ -0,0 +1,173 @@
+package slo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBurnRate(t *testing.T) {
+	for _, tc := range []struct {
+		sum    counts
+		target float64
+		want   float64
+	}{
+		{counts{}, 0.99, 0},
+		{counts{good: 100, total: 100}, 0.99, 0},
+		{counts{good: 99, total: 100}, 0.99, 1},
+		{counts{good: 0, total: 10}, 0.999, 1000},
+		{counts{good: 0, total: 10}, 1, 0},
+	} {
+		if got := burnRate(tc.sum, tc.target); got < tc.want-1e-9 || got > tc.want+1e-9 {
+			t.Errorf("burnRate(%+v, %v) = %v, want %v", tc.sum, tc.target, got, tc.want)
+		}
+	}
+}
+
+func newTestTracker(t *testing.T) *Tracker {
+	t.Helper()
+
+	tracker, err := NewTracker()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tracker
+}
+
+// events adds n good or bad events, dated ago before now, to the i-th
+// objective of tracker.
+func events(tracker *Tracker, i int, ago time.Duration, n int, good bool) {
+	s := tracker.all()[i]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ; n > 0; n-- {
+		s.series.add(time.Now().Add(-ago), good)
+	}
+}
+
+func TestStatusStates(t *testing.T) {
+	tracker := newTestTracker(t)
+	tracker.Route("/ok", Availability(0.99))
+	tracker.Route("/critical", Availability(0.99))
+	tracker.Route("/warning", Availability(0.99))
+	tracker.Route("/recovered", Availability(0.99))
+
+	events(tracker, 0, 0, 100, true)
+	events(tracker, 0, 0, 1, false)
+
+	// Every window burns at 100.
+	events(tracker, 1, 0, 10, false)
+
+	// 9 bad events 25 minutes ago, of 110 in total, burn the 30m, 1h and
+	// 6h windows at 8.2 and not the 5m one.
+	events(tracker, 2, 25*time.Minute, 9, false)
+	events(tracker, 2, 25*time.Minute, 1, true)
+	events(tracker, 2, 0, 100, true)
+
+	// Only the 6h window still burns.
+	events(tracker, 3, 2*time.Hour, 10, false)
+
+	want := map[string]string{
+		"/ok":        StateOk,
+		"/critical":  StateCritical,
+		"/warning":   StateWarning,
+		"/recovered": StateOk,
+	}
+
+	for _, status := range tracker.Status() {
+		if status.State != want[status.Route] {
+			t.Errorf("%s is %s, want %s: %+v", status.Route, status.State, want[status.Route], status.Windows)
+		}
+
+		if len(status.Windows) != len(Windows) {
+			t.Errorf("%s has %d windows", status.Route, len(status.Windows))
+		}
+	}
+}
+
+func TestRouteAccountsRequests(t *testing.T) {
+	tracker := newTestTracker(t)
+	delay := 0 * time.Millisecond
+	status := http.StatusOK
+
+	h := tracker.Route("/route", Availability(0.999), Latency(20*time.Millisecond, 0.99))(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(delay)
+			w.WriteHeader(status)
+		}),
+	)
+	serve := func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/route", nil))
+	}
+
+	serve()
+	status = http.StatusInternalServerError
+	serve()
+	status, delay = http.StatusNotFound, 30*time.Millisecond
+	serve()
+
+	statuses := tracker.Status()
+
+	if len(statuses) != 2 {
+		t.Fatalf("%d statuses", len(statuses))
+	}
+
+	availability, latency := statuses[0], statuses[1]
+
+	// Client errors are good events, server errors are not.
+	if w := availability.Windows[0]; availability.Objective != "availability" || w.Good != 2 || w.Total != 3 || availability.Latency != "" {
+		t.Errorf("availability %+v", availability)
+	}
+
+	// Failed and slow requests are bad.
+	if w := latency.Windows[0]; latency.Objective != "latency" || w.Good != 1 || w.Total != 3 || latency.Latency != "20ms" {
+		t.Errorf("latency %+v", latency)
+	}
+}
+
+func TestNewAdminRoute(t *testing.T) {
+	tracker := newTestTracker(t)
+	tracker.Route("/route", Latency(100*time.Millisecond, 0.99))
+	events(tracker, 0, 0, 3, true)
+	events(tracker, 0, 0, 1, false)
+
+	route := NewAdminRoute(tracker)
+
+	if route.Path != "/slo" {
+		t.Fatalf("served on %s", route.Path)
+	}
+
+	w := httptest.NewRecorder()
+	route.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slo", nil))
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type %q", w.Header().Get("Content-Type"))
+	}
+
+	var statuses []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 1 {
+		t.Fatalf("statuses %v", statuses)
+	}
+
+	status := statuses[0]
+
+	if status["route"] != "/route" || status["objective"] != "latency" || status["target"] != 0.99 ||
+		status["latency"] != "100ms" || status["state"] != StateCritical {
+		t.Errorf("status %v", status)
+	}
+
+	window := status["windows"].([]interface{})[0].(map[string]interface{})
+
+	if window["window"] != "5m" || window["good"] != 3.0 || window["total"] != 4.0 || window["burnRate"].(float64) < 24.9 {
+		t.Errorf("window %v", window)
+	}
+}
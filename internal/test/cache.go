@@ -0,0 +1,27 @@
+package test
+
+import (
+	"context"
+	"gokit-seed/internal/cache"
+)
+
+type cachemw struct {
+	TestService
+	cache *cache.Cache
+}
+
+// MakeCachingTestService caches Hello results, which do not depend on the
+// caller. It is a no-op when c is nil.
+func MakeCachingTestService(c *cache.Cache) ServiceMiddleware {
+	return func(ts TestService) TestService {
+		if c == nil {
+			return ts
+		}
+
+		return cachemw{ts, c}
+	}
+}
+
+func (mw cachemw) Hello(ctx context.Context) (string, error) {
+	return cache.GetJson(ctx, mw.cache, "test.hello", mw.TestService.Hello)
+}
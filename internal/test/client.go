@@ -0,0 +1,65 @@
+package test
+
+import (
+	"context"
+	"gokit-seed/internal/common"
+	otelhttputil "gokit-seed/internal/otel/go-kit"
+	"net/url"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"go.uber.org/zap"
+)
+
+type client struct {
+	reverseEndpoint endpoint.Endpoint
+	helloEndpoint   endpoint.Endpoint
+}
+
+// NewClient returns a TestService calling the test service at baseUrl, such
+// as "http://localhost:3001". opts apply to every method, e.g. to set the
+// HTTP client.
+func NewClient(baseUrl string, opts ...kithttp.ClientOption) (TestService, error) {
+	base, err := url.Parse(baseUrl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{
+		reverseEndpoint: otelhttputil.TraceClient(serviceName, "Reverse")(
+			otelhttputil.NewClient[ReverseResponse](
+				"POST",
+				base.JoinPath(groupPath, reversePath),
+				kithttp.EncodeJSONRequest,
+				opts...,
+			),
+		),
+		helloEndpoint: otelhttputil.TraceClient(serviceName, "Hello")(
+			otelhttputil.NewClient[HelloResponse](
+				"GET",
+				base.JoinPath(groupPath, helloPath),
+				otelhttputil.EncodeNopRequest,
+				opts...,
+			),
+		),
+	}, nil
+}
+
+// Reverse can not report errors through TestService, they are logged and an
+// empty string is returned.
+func (c *client) Reverse(s string) string {
+	ctx := context.Background()
+	response, err := otelhttputil.Invoke[ReverseResponse](ctx, c.reverseEndpoint, ReverseRequest{Value: s})
+
+	if err != nil {
+		common.LoggerFromContext(ctx).Error("reverse call failed", zap.Error(err))
+	}
+
+	return response.Result
+}
+
+func (c *client) Hello(ctx context.Context) (string, error) {
+	response, err := otelhttputil.Invoke[HelloResponse](ctx, c.helloEndpoint, nil)
+	return response.Result, err
+}
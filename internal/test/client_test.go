@@ -0,0 +1,61 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+func TestClientCallsTheTestService(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+groupPath+reversePath, func(w http.ResponseWriter, r *http.Request) {
+		var request ReverseRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(ReverseResponse{Result: NewTestService().Reverse(request.Value)})
+	})
+	mux.HandleFunc("GET "+groupPath+helloPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(HelloResponse{Result: "Hello world!"})
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, kithttp.SetClient(server.Client()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := client.Reverse("abc"); got != "cba" {
+		t.Errorf("Reverse got %q", got)
+	}
+
+	// Upstream errors are returned.
+	if _, err := client.Hello(context.Background()); err == nil {
+		t.Error("unauthorized call succeeded")
+	}
+
+	authorized, err := NewClient(server.URL, kithttp.SetClient(server.Client()), kithttp.ClientBefore(kithttp.SetRequestHeader("Authorization", "Bearer token")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := authorized.Hello(context.Background()); err != nil || got != "Hello world!" {
+		t.Errorf("Hello got %q, %v", got, err)
+	}
+}
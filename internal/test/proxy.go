@@ -2,19 +2,22 @@ package test
 
 import (
 	"context"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/balancer"
 	"gokit-seed/internal/common"
 	"gokit-seed/internal/otel"
+	otelhttputil "gokit-seed/internal/otel/go-kit"
+	"gokit-seed/internal/resilience"
+	"io"
+	"net/http"
 	"strings"
-	"time"
 
-	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
-	kitratelimit "github.com/go-kit/kit/ratelimit"
 	kitsd "github.com/go-kit/kit/sd"
-	"github.com/go-kit/kit/sd/lb"
 	kithttp "github.com/go-kit/kit/transport/http"
-	"github.com/sony/gobreaker"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/otel/baggage"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
 )
 
 type proxymw struct {
@@ -22,34 +25,113 @@ type proxymw struct {
 	helloEndpoint endpoint.Endpoint
 }
 
-func MakeProxyTestService(proxyUrl *string) ServiceMiddleware {
+type proxyConfig struct {
+	client     *http.Client
+	resilience resilience.Config
+	logger     *zap.Logger
+	instancer  kitsd.Instancer
+	strategy   balancer.Strategy
+	lifecycle  fx.Lifecycle
+}
+
+type ProxyOption func(*proxyConfig)
+
+// WithClient sets the HTTP client used to call upstreams, otel.DefaultClient
+// by default.
+func WithClient(client *http.Client) ProxyOption {
+	return func(c *proxyConfig) {
+		c.client = client
+	}
+}
+
+// WithResilience sets the breaker, limiter, retry and bulkhead policy of the
+// upstream, resilience.DefaultConfig() by default.
+func WithResilience(config resilience.Config) ProxyOption {
+	return func(c *proxyConfig) {
+		c.resilience = config
+	}
+}
+
+func WithLogger(logger *zap.Logger) ProxyOption {
+	return func(c *proxyConfig) {
+		c.logger = logger
+	}
+}
+
+// WithInstancer discovers upstream instances dynamically instead of using the
+// fixed list of URLs.
+func WithInstancer(instancer kitsd.Instancer) ProxyOption {
+	return func(c *proxyConfig) {
+		c.instancer = instancer
+	}
+}
+
+// WithBalancer sets the load balancing strategy across upstream instances,
+// round robin by default.
+func WithBalancer(strategy balancer.Strategy) ProxyOption {
+	return func(c *proxyConfig) {
+		c.strategy = strategy
+	}
+}
+
+// WithLifecycle stops load balancing and ejection, but not the instancer,
+// when the application stops.
+func WithLifecycle(lc fx.Lifecycle) ProxyOption {
+	return func(c *proxyConfig) {
+		c.lifecycle = lc
+	}
+}
+
+func MakeProxyTestService(proxyUrl *string, opts ...ProxyOption) ServiceMiddleware {
+	cfg := proxyConfig{
+		client:     otel.DefaultClient,
+		resilience: resilience.DefaultConfig(),
+		logger:     zap.L(),
+		strategy:   balancer.RoundRobin,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	return func(ts TestService) TestService {
-		if proxyUrl == nil {
-			return ts
-		}
+		instancer := cfg.instancer
 
-		instanceUrls := strings.Split((*proxyUrl), ",")
+		if instancer == nil {
+			if proxyUrl == nil {
+				return ts
+			}
 
-		if len(instanceUrls) == 0 {
-			return ts
+			instancer = kitsd.FixedInstancer(strings.Split(*proxyUrl, ","))
 		}
 
-		var endpointers = kitsd.FixedEndpointer{}
+		ejector := resilience.NewEjector("test", instancer, cfg.resilience.Ejection, cfg.logger)
+		// Shared by the instances, so that it bounds the attempts in progress
+		// to the upstream, hedges included, and no slot is held while backing
+		// off between retries.
+		bulkhead := resilience.Bulkhead("test", cfg.resilience.Bulkhead)
 
-		for _, instanceUrl := range instanceUrls {
-			e := makeHelloProxy(instanceUrl)
-			e = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{}))(e)
-			e = kitratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(5*time.Second), 1))(e)
+		factory := func(instanceUrl string) (endpoint.Endpoint, io.Closer, error) {
+			policy, closer := resilience.Instance("test", instanceUrl, cfg.resilience, cfg.logger)
+			e := endpoint.Chain(bulkhead, ejector.Middleware(instanceUrl), policy)(makeHelloProxy(instanceUrl, cfg.client))
+			return e, closer, nil
+		}
 
-			endpointers = append(endpointers, e)
+		endpointer := balancer.NewEndpointer("test", ejector, factory, cfg.logger, balancer.WithRefused(resilience.Refused))
+
+		if cfg.lifecycle != nil {
+			cfg.lifecycle.Append(fx.StopHook(func() {
+				endpointer.Close()
+				ejector.Stop()
+			}))
 		}
 
-		loadbalancer := lb.NewRoundRobin(endpointers)
-		retry := lb.Retry(1, time.Duration(5*time.Second), loadbalancer)
+		loadbalancer := resilience.Hedge("test", cfg.resilience, cfg.strategy(endpointer))
+		retry := resilience.Retry(cfg.resilience.Retry, loadbalancer)
 
 		return proxymw{
 			ts,
-			retry,
+			otelhttputil.TraceClient(serviceName, "Hello")(retry),
 		}
 	}
 }
@@ -59,23 +141,30 @@ func (p proxymw) Reverse(s string) string {
 }
 
 func (p proxymw) Hello(ctx context.Context) (string, error) {
-	response, responseErr := p.helloEndpoint(ctx, nil)
+	ctx = balancer.ContextWithKey(ctx, balancingKey(ctx))
+	response, err := otelhttputil.Invoke[HelloResponse](ctx, p.helloEndpoint, nil)
+	return response.Result, err
+}
 
-	if responseErr != nil {
-		return "", responseErr
+// balancingKey keeps the calls of a tenant, or else of a caller, on the same
+// instance under consistent hashing. Anonymous calls are spread by request id.
+func balancingKey(ctx context.Context) string {
+	if tenant := baggage.FromContext(ctx).Member("tenant.id").Value(); tenant != "" {
+		return "tenant:" + tenant
 	}
 
-	responseData := response.(HelloResponse)
+	if principal := auth.PrincipalFromContext(ctx); principal != nil && principal.Subject != "" {
+		return "subject:" + principal.Subject
+	}
 
-	return responseData.Result, nil
+	return common.RequestIdFromContext(ctx)
 }
 
-func makeHelloProxy(url string) endpoint.Endpoint {
-	return kithttp.NewClient(
+func makeHelloProxy(url string, client *http.Client) endpoint.Endpoint {
+	return otelhttputil.NewClient[HelloResponse](
 		"GET",
 		common.MustParseUrl(url),
-		kithttp.EncodeJSONRequest,
-		decodeHelloResponse,
-		kithttp.SetClient(otel.DefaultClient),
-	).Endpoint()
+		otelhttputil.EncodeNopRequest,
+		kithttp.SetClient(client),
+	)
 }
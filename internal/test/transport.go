@@ -2,13 +2,22 @@ package test
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"gokit-seed/internal/admission"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/cache"
 	"gokit-seed/internal/common"
 	otelutil "gokit-seed/internal/otel"
 	otelhttputil "gokit-seed/internal/otel/go-kit"
+	"gokit-seed/internal/policy"
+	"gokit-seed/internal/profiling"
+	"gokit-seed/internal/ratelimit"
+	"gokit-seed/internal/resilience"
+	"gokit-seed/internal/slo"
 	"net/http"
+	"time"
 
+	"github.com/go-kit/kit/endpoint"
 	kitzap "github.com/go-kit/kit/log/zap"
 	"github.com/go-kit/kit/sd/lb"
 	"github.com/go-kit/kit/transport"
@@ -17,7 +26,24 @@ import (
 	"go.uber.org/zap"
 )
 
-func MakeHandler(logger *zap.Logger, sv TestService) (router *common.RouteGroup) {
+const (
+	serviceName = "TestService"
+	groupPath   = "/strings"
+	reversePath = "/reversions"
+	helloPath   = "/greetings"
+)
+
+func MakeHandler(
+	logger *zap.Logger,
+	sv TestService,
+	verifier *auth.Verifier,
+	keyring *auth.Keyring,
+	policies *policy.Engine,
+	limiter *ratelimit.Limiter,
+	admissions *admission.Controller,
+	responseCache *cache.Cache,
+	objectives *slo.Tracker,
+) (*common.RouteGroup, error) {
 	opts := []kithttp.ServerOption{
 		kithttp.ServerErrorHandler(
 			transport.NewLogErrorHandler(
@@ -27,51 +53,105 @@ func MakeHandler(logger *zap.Logger, sv TestService) (router *common.RouteGroup)
 		kithttp.ServerErrorEncoder(decodeHelloError),
 	}
 
-	router = common.NewRouteGroup("/strings")
+	router := common.NewRouteGroup(groupPath)
+	admit := admissions.Group(router.Path)
+	reverseAuthorization, err := policies.Authorize(router.PolicyName(reversePath))
+
+	if err != nil {
+		return nil, err
+	}
+
+	helloAuthorization, err := policies.Authorize(router.PolicyName(helloPath))
+
+	if err != nil {
+		return nil, err
+	}
 
 	var reverseHandler http.Handler
 	reverseHandler = kithttp.NewServer(
-		makeReverseEndpoint(sv),
-		decodeReverseRequest,
+		endpoint.Chain(
+			otelhttputil.TraceEndpoint(serviceName, "Reverse"),
+			otelhttputil.Deadline,
+			verifier.RequireScopes("strings:reverse"),
+			reverseAuthorization,
+		)(makeReverseEndpoint(sv)),
+		otelhttputil.DecodeJsonRequest[ReverseRequest],
 		otelhttputil.DefaultJsonEncoder,
 		opts...,
 	)
-	reversePath := "/reversions"
-  reverseHandler = common.BaseHandler(logger, reverseHandler, otelutil.WithTraceIdLog)
+	// Within the access log, so that rejected requests are logged too, and
+	// rate limited by client IP before the cost of authenticating them, then
+	// by caller once verified. Admission sheds by the priority of
+	// authenticated callers.
+	reverseLimit := ratelimit.Limit{Rate: 10, Burst: 20}
+	reverseHandler = limiter.Authenticated(router.SubPath(reversePath), reverseLimit)(admit(reverseHandler))
+	reverseHandler = keyring.VerifySignature(verifier.Authenticate(reverseHandler))
+	reverseHandler = limiter.Route(router.SubPath(reversePath), reverseLimit)(reverseHandler)
+	reverseHandler = common.BaseHandler(
+		logger,
+		reverseHandler,
+		common.WithTimeout(router.SubPath(reversePath), time.Second),
+		otelutil.WithTraceIdLog,
+		otelutil.WithBaggage,
+		profiling.WithTraceLabels,
+		common.WithCompression,
+		otelutil.WithRouteMetrics(router.SubPath(reversePath)),
+		objectives.Route(
+			router.SubPath(reversePath),
+			slo.Availability(0.999),
+			slo.Latency(100*time.Millisecond, 0.99),
+		),
+	)
 	reverseHandler = otelhttp.WithRouteTag(router.SubPath(reversePath), reverseHandler)
-	reverseHandler = otelhttp.NewHandler(reverseHandler, router.SubPath(reversePath))
+	reverseHandler = otelutil.NewHandler(reverseHandler, router.SubPath(reversePath))
 	router.Handler("POST", reversePath, reverseHandler)
 
 	var helloHandler http.Handler
 	helloHandler = kithttp.NewServer(
-		makeHelloEndpoint(sv),
+		endpoint.Chain(
+			otelhttputil.TraceEndpoint(serviceName, "Hello"),
+			otelhttputil.Deadline,
+			verifier.RequireScopes("strings:read"),
+			helloAuthorization,
+		)(makeHelloEndpoint(sv)),
 		kithttp.NopRequestDecoder,
-		otelhttputil.DefaultJsonEncoder,
-		opts...,
+		otelhttputil.CachingJsonEncoder(responseCache.TTL()),
+		append(opts, kithttp.ServerBefore(otelhttputil.IfNoneMatchToContext))...,
+	)
+	// Within the access log, so that rejected requests are logged too, and
+	// rate limited by client IP before the cost of authenticating them, then
+	// by caller once verified. Admission sheds by the priority of
+	// authenticated callers.
+	helloLimit := ratelimit.Limit{Rate: 20, Burst: 40}
+	helloHandler = limiter.Authenticated(router.SubPath(helloPath), helloLimit)(admit(helloHandler))
+	helloHandler = keyring.VerifySignature(verifier.Authenticate(helloHandler))
+	helloHandler = limiter.Route(router.SubPath(helloPath), helloLimit)(helloHandler)
+	helloHandler = common.BaseHandler(
+		logger,
+		helloHandler,
+		common.WithTimeout(router.SubPath(helloPath), 3*time.Second),
+		otelutil.WithTraceIdLog,
+		otelutil.WithBaggage,
+		profiling.WithTraceLabels,
+		common.WithCompression,
+		otelutil.WithRouteMetrics(router.SubPath(helloPath)),
+		objectives.Route(
+			router.SubPath(helloPath),
+			slo.Availability(0.999),
+			slo.Latency(300*time.Millisecond, 0.99),
+		),
 	)
-	helloPath := "/greetings"
-	helloHandler = common.BaseHandler(logger, helloHandler, otelutil.WithTraceIdLog)
 	helloHandler = otelhttp.WithRouteTag(router.SubPath(helloPath), helloHandler)
-	helloHandler = otelhttp.NewHandler(helloHandler, router.SubPath(helloPath))
+	helloHandler = otelutil.NewHandler(helloHandler, router.SubPath(helloPath))
 	router.Handler("GET", helloPath, helloHandler)
 
-	return
+	return router, nil
 }
 
 type ReverseRequest struct {
 	Value string `json:"value"`
 }
 
-func decodeReverseRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	body := ReverseRequest{}
-
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		return nil, err
-	}
-
-	return body, nil
-}
-
 type ReverseResponse struct {
 	Result string `json:"result"`
 }
@@ -80,18 +160,28 @@ type HelloResponse struct {
 	Result string `json:"result"`
 }
 
-func decodeHelloResponse(_ context.Context, r *http.Response) (interface{}, error) {
-	body := HelloResponse{}
+func decodeHelloError(ctx context.Context, err error, w http.ResponseWriter) {
+	var timeoutErr *common.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		return nil, err
+	var tooLargeErr *http.MaxBytesError
+	if errors.As(err, &tooLargeErr) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
 	}
 
-	return body, nil
-}
+	var retryErr lb.RetryError
+	if errors.As(err, &retryErr) {
+		// The bulkhead wraps each attempt, saturation keeps its 503.
+		var bulkheadErr *resilience.BulkheadError
+		if errors.As(retryErr.Final, &bulkheadErr) {
+			kithttp.DefaultErrorEncoder(ctx, bulkheadErr, w)
+			return
+		}
 
-func decodeHelloError(ctx context.Context, err error, w http.ResponseWriter) {
-	if errors.As(err, &lb.RetryError{}) {
 		w.WriteHeader(http.StatusTooManyRequests)
 		return
 	}
@@ -3,21 +3,40 @@ package main
 import (
 	"context"
 	"fmt"
+	"gokit-seed/internal/admin"
+	"gokit-seed/internal/admission"
+	"gokit-seed/internal/auth"
+	"gokit-seed/internal/balancer"
+	"gokit-seed/internal/cache"
 	"gokit-seed/internal/common"
+	"gokit-seed/internal/discovery"
 	"gokit-seed/internal/otel"
+	"gokit-seed/internal/policy"
+	"gokit-seed/internal/profiling"
+	"gokit-seed/internal/ratelimit"
+	"gokit-seed/internal/redis"
+	"gokit-seed/internal/resilience"
+	"gokit-seed/internal/slo"
 	"gokit-seed/internal/test"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.opentelemetry.io/contrib/bridges/otelzap"
 	"go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/metric"
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
@@ -29,14 +48,25 @@ func main() {
 		}
 	}
 
+	if err := otel.ConfigureDefaultClient(); err != nil {
+		panic(err)
+	}
+
 	var (
-		TEST_URL = common.GetEnv("TEST_URL")
+		TEST_URL         = common.GetEnv("TEST_URL")
+		TEST_DISCOVERY   = common.GetEnv("TEST_DISCOVERY")
+		TEST_BALANCER    = os.Getenv("TEST_BALANCER")
+		TEST_COMPRESSION = os.Getenv("TEST_COMPRESSION")
 	)
 
+	start := time.Now()
+
 	fx.New(
 		fx.Provide(
 			NewLoggerProvider,
+			NewLogLevel,
 			NewLogger,
+			admin.NewMetrics,
 		),
 		fx.WithLogger(func(logger *zap.Logger) fxevent.Logger {
 			fxLogger := &fxevent.ZapLogger{Logger: logger}
@@ -44,23 +74,89 @@ func main() {
 			return fxLogger
 		}),
 		fx.Invoke(SetupOtelSdk),
+		fx.Provide(profiling.NewProfiler),
 		fx.Provide(
+			NewGrpcServer,
 			NewHttpServer,
+			auth.NewVerifier,
+			auth.NewKeyring,
+			fx.Annotate(
+				policy.NewEngine,
+				fx.ParamTags(`group:"policy_predicates"`),
+			),
+			redis.NewClientFromEnv,
+			ratelimit.NewLimiter,
+			cache.NewCache,
+			admission.NewController,
+			slo.NewTracker,
+			asAdminRoute(slo.NewAdminRoute),
 			fx.Annotate(
 				NewMuxServer,
 				fx.ParamTags(`group:"routes"`),
 			),
+			fx.Annotate(
+				admin.NewServer,
+				fx.ParamTags(``, ``, ``, ``, `group:"routes"`, `group:"admin_routes"`),
+			),
 			// Add more services here
-			func() test.TestService {
+			resilience.LoadConfigs,
+			func(lc fx.Lifecycle, logger *zap.Logger, keyring *auth.Keyring, resilienceConfigs resilience.Configs, responseCache *cache.Cache) (test.TestService, error) {
+				client := otel.DefaultClient
+
+				// Below signing, which covers the uncompressed body.
+				if TEST_COMPRESSION != "" {
+					compressed, err := otel.CompressClient(client, TEST_COMPRESSION)
+
+					if err != nil {
+						return nil, err
+					}
+
+					client = compressed
+				}
+
+				client = keyring.WrapClient(client)
+				opts := []test.ProxyOption{
+					test.WithClient(client),
+					test.WithResilience(resilienceConfigs.For("test")),
+					test.WithLogger(logger),
+					test.WithLifecycle(lc),
+				}
+
+				strategy, err := balancer.ParseStrategy(TEST_BALANCER)
+
+				if err != nil {
+					return nil, err
+				}
+
+				opts = append(opts, test.WithBalancer(strategy))
+
+				if TEST_DISCOVERY != nil {
+					instancer, err := discovery.New(*TEST_DISCOVERY, otel.DefaultClient, logger)
+
+					if err != nil {
+						return nil, err
+					}
+
+					lc.Append(fx.StopHook(instancer.Stop))
+					opts = append(opts, test.WithInstancer(instancer))
+				}
+
 				testService := test.NewTestService()
-				testService = test.MakeProxyTestService(TEST_URL)(testService)
-				return testService
+				testService = test.MakeProxyTestService(TEST_URL, opts...)(testService)
+				testService = test.MakeCachingTestService(responseCache)(testService)
+				return testService, nil
 			},
 
 			// Add more routes here
 			asRoute(test.MakeHandler),
 		),
-		fx.Invoke(func(*http.Server) {}),
+		fx.Invoke(func(*http.Server, *admin.Server, *profiling.Profiler) {}),
+		// Start hooks run in order, this one runs once everything else started.
+		fx.Invoke(func(lc fx.Lifecycle) {
+			lc.Append(fx.StartHook(func() {
+				otel.SetStartDuration(time.Since(start))
+			}))
+		}),
 	).Run()
 }
 
@@ -68,7 +164,19 @@ func NewLoggerProvider() (provider *log.LoggerProvider, err error) {
 	return otel.NewLoggerProvider(context.Background())
 }
 
-func NewLogger(lgp *log.LoggerProvider) (*zap.Logger, error) {
+// NewLogLevel reads the initial log level from LOG_LEVEL (debug by default).
+// The level can be changed at runtime through the admin /loglevel endpoint.
+func NewLogLevel() (zap.AtomicLevel, error) {
+	level := os.Getenv("LOG_LEVEL")
+
+	if level == "" {
+		return zap.NewAtomicLevelAt(zap.DebugLevel), nil
+	}
+
+	return zap.ParseAtomicLevel(level)
+}
+
+func NewLogger(lgp *log.LoggerProvider, level zap.AtomicLevel) (*zap.Logger, error) {
 	var cores []zapcore.Core
 
 	if shouldLogLoki := common.DefaultGetEnvBool("LOG_LOKI", false); shouldLogLoki {
@@ -81,13 +189,19 @@ func NewLogger(lgp *log.LoggerProvider) (*zap.Logger, error) {
 		cores = append(cores, stdoutCore)
 	}
 
-	logger := zap.New(zapcore.NewTee(cores...))
+	logger := zap.New(zapcore.NewTee(cores...), zap.IncreaseLevel(level))
 	zap.ReplaceGlobals(logger)
 	return logger, nil
 }
 
-func SetupOtelSdk(lc fx.Lifecycle, logger *zap.Logger, lgp *log.LoggerProvider) error {
-	shutdownFunc, err := otel.SetupOTelSdk(lgp)
+func SetupOtelSdk(lc fx.Lifecycle, logger *zap.Logger, lgp *log.LoggerProvider, metrics *admin.Metrics) error {
+	var readers []metric.Reader
+
+	if metrics != nil {
+		readers = append(readers, metrics.Reader)
+	}
+
+	shutdownFunc, err := otel.SetupOTelSdk(lgp, readers...)
 
 	lc.Append(fx.Hook{
 		OnStop: func(ctx context.Context) error {
@@ -99,12 +213,26 @@ func SetupOtelSdk(lc fx.Lifecycle, logger *zap.Logger, lgp *log.LoggerProvider)
 	return err
 }
 
-func NewHttpServer(lc fx.Lifecycle, handler http.Handler, logger *zap.Logger) *http.Server {
+func NewGrpcServer() *grpc.Server {
+	server := grpc.NewServer()
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	// Register more gRPC services here
+
+	return server
+}
+
+// NewHttpServer serves HTTP/1.1, h2c and gRPC on the same PORT. Connections are
+// split by common.ConnMux and both servers are drained in one shutdown sequence.
+func NewHttpServer(lc fx.Lifecycle, handler http.Handler, grpcServer *grpc.Server, logger *zap.Logger) *http.Server {
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%s", (common.MustGetEnv("PORT"))),
-		Handler: handler,
+		Handler: h2c.NewHandler(handler, &http2.Server{}),
 	}
 
+	var connMux *common.ConnMux
+
 	lc.Append(fx.Hook{
 		OnStart: func(_ context.Context) error {
 			ln, err := net.Listen("tcp", server.Addr)
@@ -113,40 +241,77 @@ func NewHttpServer(lc fx.Lifecycle, handler http.Handler, logger *zap.Logger) *h
 				return err
 			}
 
+			connMux = common.NewConnMux(ln)
+
 			logger.Info(
 				"server is listening",
 				zap.String("addr", server.Addr),
 			)
-			go server.Serve(ln)
+			go server.Serve(connMux.HttpListener())
+			go grpcServer.Serve(connMux.GrpcListener())
+			go connMux.Serve()
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
 			logger.Info("server is shutting down")
-			return server.Shutdown(ctx)
+
+			// Not set when listening failed.
+			if connMux != nil {
+				connMux.Close()
+			}
+
+			grpcStopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(grpcStopped)
+			}()
+
+			err := server.Shutdown(ctx)
+
+			select {
+			case <-grpcStopped:
+			case <-ctx.Done():
+				grpcServer.Stop()
+			}
+
+			return err
 		},
 	})
 
 	return server
 }
 
-func NewMuxServer(routes []*common.RouteGroup, logger *zap.Logger) http.Handler {
+func NewMuxServer(routes []*common.RouteGroup, logger *zap.Logger, profiler *profiling.Profiler) (http.Handler, error) {
 	mux := http.NewServeMux()
 
 	for _, route := range routes {
 		path := route.Path + "/"
-		mux.Handle(path, route)
+		var handler http.Handler = route
+
+		cors, err := common.NewCorsFromEnv(route.Path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if cors != nil {
+			handler = cors.Handler(handler)
+		}
+
+		mux.Handle(path, handler)
 	}
 
-	// add pprof to mux handler only if in development
-  if os.Getenv("GO_ENV") != "production" {
-    mux.HandleFunc("/debug/pprof/", pprof.Index)
-    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-  }
+	// add pprof to mux handler only if in development and the admin listener,
+	// which always serves pprof, is disabled
+	if os.Getenv("GO_ENV") != "production" && common.GetEnv("ADMIN_PORT") == nil {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.Handle("/debug/pprof/profile", profiler.Yield(http.HandlerFunc(pprof.Profile)))
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
 
-	return mux
+	return mux, nil
 }
 
 func asRoute(handlerFactory any) any {
@@ -155,3 +320,10 @@ func asRoute(handlerFactory any) any {
 		fx.ResultTags(`group:"routes"`),
 	)
 }
+
+func asAdminRoute(routeFactory any) any {
+	return fx.Annotate(
+		routeFactory,
+		fx.ResultTags(`group:"admin_routes"`),
+	)
+}